@@ -0,0 +1,285 @@
+// oidc-stub - локальный OpenID Connect провайдер для проверки входа через OIDC
+// без внешних сервисов. Выдает ID токен для одного настраиваемого пользователя.
+//
+//	go run ./cmd/oidc-stub -email user@example.com -name "Тестовый пользователь"
+//
+// В .env магазина: OIDC_ISSUER=http://localhost:9000, OIDC_CLIENT_ID=online-store,
+// OIDC_CLIENT_SECRET=stub-secret
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "stub-1"
+
+// stubUser - пользователь, подтвердивший вход на странице заглушки
+type stubUser struct {
+	Subject  string
+	Email    string
+	Name     string
+	Verified bool
+}
+
+// authCode - выданный, но еще не обменянный код авторизации
+type authCode struct {
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	User          stubUser
+	ExpiresAt     time.Time
+}
+
+type stub struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	email        string
+	name         string
+	verified     bool
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authCode
+}
+
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="UTF-8"><title>OIDC stub</title></head>
+<body>
+    <h1>Тестовый провайдер</h1>
+    <p>Приложение {{.ClientID}} запрашивает вход.</p>
+    <form method="POST">
+        <p><label>Subject <input name="sub" value="{{.Subject}}"></label></p>
+        <p><label>Email <input name="email" value="{{.Email}}"></label></p>
+        <p><label>Имя <input name="name" value="{{.Name}}"></label></p>
+        <p><label><input type="checkbox" name="email_verified" value="true" {{if .Verified}}checked{{end}}> Email подтвержден</label></p>
+        <button type="submit" name="decision" value="allow">Войти</button>
+        <button type="submit" name="decision" value="deny">Отказать</button>
+    </form>
+</body>
+</html>`))
+
+func main() {
+	addr := flag.String("addr", ":9000", "адрес сервера")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer (внешний адрес заглушки)")
+	clientID := flag.String("client-id", "online-store", "client_id приложения")
+	clientSecret := flag.String("client-secret", "stub-secret", "client_secret приложения")
+	email := flag.String("email", "user@example.com", "email пользователя по умолчанию")
+	name := flag.String("name", "Тестовый пользователь", "имя пользователя по умолчанию")
+	verified := flag.Bool("verified", true, "провайдер подтверждает email")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("Ошибка генерации ключа:", err)
+	}
+
+	s := &stub{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		email:        *email,
+		name:         *name,
+		verified:     *verified,
+		key:          key,
+		codes:        make(map[string]authCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discoveryHandler)
+	mux.HandleFunc("/authorize", s.authorizeHandler)
+	mux.HandleFunc("/token", s.tokenHandler)
+	mux.HandleFunc("/jwks", s.jwksHandler)
+
+	log.Printf("OIDC заглушка %s слушает %s", s.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func (s *stub) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *stub) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != s.clientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid client_id or response_type", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		authorizePage.Execute(w, map[string]interface{}{
+			"ClientID": s.clientID,
+			"Subject":  "stub|" + s.email,
+			"Email":    s.email,
+			"Name":     s.name,
+			"Verified": s.verified,
+		})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := url.Values{"state": {query.Get("state")}}
+
+	if r.FormValue("decision") != "allow" {
+		params.Set("error", "access_denied")
+	} else {
+		code := randomString()
+
+		s.mu.Lock()
+		s.codes[code] = authCode{
+			RedirectURI:   query.Get("redirect_uri"),
+			Nonce:         query.Get("nonce"),
+			CodeChallenge: query.Get("code_challenge"),
+			User: stubUser{
+				Subject:  r.FormValue("sub"),
+				Email:    r.FormValue("email"),
+				Name:     r.FormValue("name"),
+				Verified: r.FormValue("email_verified") == "true",
+			},
+			ExpiresAt: time.Now().Add(time.Minute),
+		}
+		s.mu.Unlock()
+
+		params.Set("code", code)
+	}
+
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *stub) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if clientID != s.clientID || clientSecret != s.clientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.FormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	// Код одноразовый: удаляем его сразу, даже если проверка ниже не пройдет
+	s.mu.Lock()
+	code, ok := s.codes[r.FormValue("code")]
+	delete(s.codes, r.FormValue("code"))
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(code.ExpiresAt) || code.RedirectURI != r.FormValue("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if code.CodeChallenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.issuer,
+		"aud":            s.clientID,
+		"sub":            code.User.Subject,
+		"email":          code.User.Email,
+		"email_verified": code.User.Verified,
+		"name":           code.User.Name,
+		"nonce":          code.Nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		log.Println("Ошибка подписи ID токена:", err)
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *stub) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatal("Ошибка генерации случайных данных:", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
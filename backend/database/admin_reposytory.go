@@ -3,6 +3,8 @@ package database
 import (
 	"context"
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"online_store/models"
@@ -12,27 +14,12 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func CheckAdminStatus(db *pgxpool.Pool, ctx context.Context, userID int) (bool, error) {
-
-	var status string
-	query := `SELECT status FROM users WHERE id = $1`
-
-	err := db.QueryRow(ctx, query, userID).Scan(&status)
-
-	if err != nil {
-		log.Println("Ошибка получения статуса Администратора", err)
-		return false, err
-	}
-
-	return status == "admin", nil
-
-}
-
 func GetAllProductsForAdmin(db *pgxpool.Pool, ctx context.Context) ([]models.ProductForAdmin, error) {
 
 	query :=
 		`SELECT products.product_id, products.name, products.description, price, stock_qty, 
-		image_url, producers.producer_id, categories.category_id, producers.name, categories.name
+		image_url, producers.producer_id, categories.category_id, producers.name, categories.name,
+		(SELECT COUNT(*) FROM product_variants WHERE product_variants.product_id = products.product_id)
 	 FROM products
 	 LEFT JOIN products_producers 
 	 ON products_producers.product_id =products.product_id 
@@ -76,7 +63,8 @@ func GetProductForAdmin(db *pgxpool.Pool, ctx context.Context, ProductID int) (m
 	var Product models.ProductForAdmin
 	query :=
 		`SELECT products.product_id, products.name, products.description, price, stock_qty, 
-		image_url, producers.producer_id, categories.category_id, producers.name, categories.name
+		image_url, producers.producer_id, categories.category_id, producers.name, categories.name,
+		(SELECT COUNT(*) FROM product_variants WHERE product_variants.product_id = products.product_id)
 	 FROM products
 	 LEFT JOIN products_producers 
 	 ON products_producers.product_id =products.product_id 
@@ -89,7 +77,7 @@ func GetProductForAdmin(db *pgxpool.Pool, ctx context.Context, ProductID int) (m
 	 WHERE products.product_id = $1`
 	err := db.QueryRow(ctx, query, ProductID).Scan(&Product.ProductID, &Product.Name, &Product.Description,
 		&Product.Price, &Product.StockQuantity, &Product.ImageURL, &Product.ProducerID,
-		&Product.CategoryID, &Product.ProducerName, &Product.CategoryName)
+		&Product.CategoryID, &Product.ProducerName, &Product.CategoryName, &Product.VariantCount)
 
 	if err != nil {
 		log.Println("Ошибка получения продукта из бд: ", err)
@@ -110,6 +98,14 @@ func UpdateProductForAdmin(db *pgxpool.Pool, ctx context.Context, product models
 		return err
 	}
 	defer tx.Rollback(ctx)
+
+	// Цену и остаток товара с вариантами выводит триггер product_variants:
+	// прямая запись разошлась бы с вариантами и затерлась при их изменении
+	err = checkVariantTotalsUnchanged(tx, ctx, product.ProductID, product.Price, product.StockQuantity)
+	if err != nil {
+		return err
+	}
+
 	queryUpdateProducts := `UPDATE products 
 							SET name = $1, description = $2, price = $3, 
 							stock_qty = $4, image_url = $5
@@ -165,7 +161,8 @@ func DeleteProductForAdmin(db *pgxpool.Pool, ctx context.Context, ProductID int)
 	return err
 }
 
-func CreateProductForAdmin(db *pgxpool.Pool, ctx context.Context, Product models.ProductForAdmin) error {
+// CreateProductForAdmin добавляет товар и возвращает его ID
+func CreateProductForAdmin(db *pgxpool.Pool, ctx context.Context, Product models.ProductForAdmin) (int, error) {
 
 	queryInsertProducts := `INSERT INTO products(name, description, price, stock_qty, image_url) 
 	VALUES ($1,$2,$3,$4,$5) RETURNING product_id`
@@ -174,7 +171,7 @@ func CreateProductForAdmin(db *pgxpool.Pool, ctx context.Context, Product models
 
 	if err != nil {
 		log.Println("Ошибка начала транзакции:", err)
-		return err
+		return 0, err
 	}
 	defer tx.Rollback(ctx)
 
@@ -189,7 +186,7 @@ func CreateProductForAdmin(db *pgxpool.Pool, ctx context.Context, Product models
 
 	if err != nil {
 		log.Println("Ошибка вставка в таблицу products:", err)
-		return err
+		return 0, err
 	}
 
 	queryInsertProductsProducers := `INSERT INTO products_producers VALUES ($1,$2)`
@@ -198,7 +195,7 @@ func CreateProductForAdmin(db *pgxpool.Pool, ctx context.Context, Product models
 
 	if err != nil {
 		log.Println("Ошибка вставка в таблицу products_producers:", err)
-		return err
+		return 0, err
 	}
 
 	queryInsertProductsCategories := `INSERT INTO products_categories VALUES ($1,$2)`
@@ -207,35 +204,51 @@ func CreateProductForAdmin(db *pgxpool.Pool, ctx context.Context, Product models
 
 	if err != nil {
 		log.Println("Ошибка вставка в таблицу products_categories :", err)
-		return err
+		return 0, err
 	}
 
 	err = tx.Commit(ctx)
 
 	if err != nil {
 		log.Println("Ошибка commit транзакции :", err)
-		return err
+		return 0, err
 	}
 
-	return nil
-}
-
-func MakeAdmin(db *pgxpool.Pool, ctx context.Context, userID int) error {
-	query := `UPDATE users SET status = 'admin' WHERE id = $1`
-	_, err := db.Exec(ctx, query, userID)
-	return err
+	return productID, nil
 }
 
+// UnbanUser возвращает аккаунт в активное состояние.
+// Удаленные аккаунты не восстанавливаются.
 func UnbanUser(db *pgxpool.Pool, ctx context.Context, userID int) error {
-	query := `UPDATE users SET status = 'user' WHERE id = $1`
+	query := `UPDATE users SET account_state = 'active' WHERE id = $1 AND account_state = 'banned'`
 	_, err := db.Exec(ctx, query, userID)
 	return err
 }
 
+// BanUser блокирует пользователя и сразу отзывает все его сессии
 func BanUser(db *pgxpool.Pool, ctx context.Context, userID int) error {
-	query := `UPDATE users SET status = 'banned' WHERE id = $1`
-	_, err := db.Exec(ctx, query, userID)
-	return err
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE users SET account_state = 'banned' WHERE id = $1 AND account_state = 'active'`
+	_, err = tx.Exec(ctx, query, userID)
+	if err != nil {
+		log.Println("Ошибка блокировки пользователя:", err)
+		return err
+	}
+
+	queryRevokeSessions := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err = tx.Exec(ctx, queryRevokeSessions, userID)
+	if err != nil {
+		log.Println("Ошибка отзыва сессий пользователя:", err)
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 func ExportProductsToCSV(db *pgxpool.Pool, ctx context.Context, writer *csv.Writer) error {
@@ -283,7 +296,41 @@ func ExportProductsToCSV(db *pgxpool.Pool, ctx context.Context, writer *csv.Writ
 	return nil
 }
 
-func ImportProductsCSV(db *pgxpool.Pool, ctx context.Context, file io.Reader) (int, error) {
+// ErrProductHasVariants - попытка задать цену или остаток товара с вариантами напрямую
+var ErrProductHasVariants = errors.New("price and stock of a product with variants are set on its variants")
+
+// checkVariantTotalsUnchanged возвращает ErrProductHasVariants, если у товара
+// есть варианты, а цена или остаток отличаются от выведенных из вариантов.
+// Цена сравнивается в базе, после приведения к NUMERIC(10,2), как при записи:
+// float32 из формы или CSV может не совпасть с хранимой ценой побитово
+func checkVariantTotalsUnchanged(tx pgx.Tx, ctx context.Context, productID int, price float32, stockQty int) error {
+	var hasVariants bool
+	var changed bool
+
+	query := `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1),
+					 price <> $2::NUMERIC(10,2) OR stock_qty <> $3
+			  FROM products WHERE product_id = $1 FOR UPDATE`
+
+	err := tx.QueryRow(ctx, query, productID, price, stockQty).Scan(&hasVariants, &changed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Новый товар - вариантов у него еще нет
+		return nil
+	}
+	if err != nil {
+		log.Println("Ошибка проверки вариантов товара:", err)
+		return err
+	}
+
+	if hasVariants && changed {
+		return fmt.Errorf("%w: product %d", ErrProductHasVariants, productID)
+	}
+	return nil
+}
+
+// ImportProductsCSV создает и обновляет товары из CSV
+// (product_id,name,description,price,stock_qty,image_url).
+// Возвращает число импортированных строк и товары, которые импорт изменил.
+func ImportProductsCSV(db *pgxpool.Pool, ctx context.Context, file io.Reader) (int, []models.ProductImportChange, error) {
 	// Читаем CSV
 	reader := csv.NewReader(file)
 	reader.Comma = ','
@@ -292,7 +339,7 @@ func ImportProductsCSV(db *pgxpool.Pool, ctx context.Context, file io.Reader) (i
 	headers, err := reader.Read()
 	if err != nil {
 		log.Println("Ошибка чтения заголовков CSV:", err)
-		return 0, err
+		return 0, nil, err
 	}
 	log.Printf("Заголовки CSV: %v", headers)
 
@@ -300,12 +347,13 @@ func ImportProductsCSV(db *pgxpool.Pool, ctx context.Context, file io.Reader) (i
 	tx, err := db.Begin(ctx)
 	if err != nil {
 		log.Println("Ошибка начала транзакции:", err)
-		return 0, err
+		return 0, nil, err
 	}
 	defer tx.Rollback(ctx)
 
 	// Импортируем данные
 	imported := 0
+	var changes []models.ProductImportChange
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
@@ -340,6 +388,36 @@ func ImportProductsCSV(db *pgxpool.Pool, ctx context.Context, file io.Reader) (i
 			continue
 		}
 
+		after := models.ProductImportFields{
+			ProductID:     productID,
+			Name:          record[1],
+			Description:   record[2],
+			Price:         float32(price),
+			StockQuantity: stockQty,
+			ImageURL:      record[5],
+		}
+
+		// Товар с вариантами: цену и остаток импорт не меняет, такой файл отклоняется целиком
+		err = checkVariantTotalsUnchanged(tx, ctx, productID, after.Price, after.StockQuantity)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Прежние значения - для журнала действий
+		var before *models.ProductImportFields
+		var existing models.ProductImportFields
+		err = tx.QueryRow(ctx, `
+			SELECT product_id, name, coalesce(description, ''), price, stock_qty, coalesce(image_url, '')
+			FROM products WHERE product_id = $1 FOR UPDATE`, productID).Scan(
+			&existing.ProductID, &existing.Name, &existing.Description,
+			&existing.Price, &existing.StockQuantity, &existing.ImageURL)
+		if err == nil {
+			before = &existing
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			log.Println("Ошибка получения товара для импорта:", err)
+			return 0, nil, err
+		}
+
 		// Вставляем или обновляем данные
 		_, err = tx.Exec(ctx, `
 			INSERT INTO products (product_id, name, description, price, stock_qty, image_url)
@@ -357,14 +435,18 @@ func ImportProductsCSV(db *pgxpool.Pool, ctx context.Context, file io.Reader) (i
 			continue
 		}
 		imported++
+
+		if before == nil || *before != after {
+			changes = append(changes, models.ProductImportChange{Before: before, After: after})
+		}
 	}
 
 	// Коммитим транзакцию
 	if err := tx.Commit(ctx); err != nil {
 		log.Println("Ошибка коммита транзакции:", err)
-		return 0, err
+		return 0, nil, err
 	}
 
 	log.Printf("Импорт завершен: %d записей", imported)
-	return imported, nil
+	return imported, changes, nil
 }
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrAPITokenNotFound возвращается, если токена нет, он истек или отозван
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+// CreateAPIToken сохраняет токен; в БД попадает только его хэш
+func CreateAPIToken(db *pgxpool.Pool, ctx context.Context, token *models.APIToken, tokenHash string) error {
+	query := `INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  RETURNING id, created_at`
+
+	err := db.QueryRow(ctx, query, token.UserID, token.Name, tokenHash, token.Prefix,
+		token.Scopes, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+
+	if err != nil {
+		log.Println("Ошибка создания API токена:", err)
+	}
+
+	return err
+}
+
+// GetActiveAPIToken ищет действующий токен по хэшу
+func GetActiveAPIToken(db *pgxpool.Pool, ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	var token models.APIToken
+
+	query := `SELECT id, user_id, name, token_prefix, scopes, created_at, expires_at, last_used_at
+			  FROM api_tokens
+			  WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+
+	err := db.QueryRow(ctx, query, tokenHash).Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix,
+		&token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPITokenNotFound
+		}
+		log.Println("Ошибка получения API токена:", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// TouchAPIToken обновляет время последнего использования
+func TouchAPIToken(db *pgxpool.Pool, ctx context.Context, id int) error {
+	_, err := db.Exec(ctx, `UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// CountActiveAPITokens - число действующих токенов пользователя
+func CountActiveAPITokens(db *pgxpool.Pool, ctx context.Context, userID int) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(*) FROM api_tokens
+			  WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+
+	err := db.QueryRow(ctx, query, userID).Scan(&count)
+	if err != nil {
+		log.Println("Ошибка подсчета API токенов:", err)
+	}
+
+	return count, err
+}
+
+// GetUserAPITokens возвращает действующие токены пользователя
+func GetUserAPITokens(db *pgxpool.Pool, ctx context.Context, userID int) ([]models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, u.email, t.name, t.token_prefix, t.scopes, t.created_at, t.expires_at, t.last_used_at
+			  FROM api_tokens t
+			  JOIN users u ON u.id = t.user_id
+			  WHERE t.user_id = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW()
+			  ORDER BY t.created_at DESC`
+
+	return queryAPITokens(db, ctx, query, userID)
+}
+
+// GetAllAPITokens возвращает действующие токены всех пользователей (для админ-панели)
+func GetAllAPITokens(db *pgxpool.Pool, ctx context.Context) ([]models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, u.email, t.name, t.token_prefix, t.scopes, t.created_at, t.expires_at, t.last_used_at
+			  FROM api_tokens t
+			  JOIN users u ON u.id = t.user_id
+			  WHERE t.revoked_at IS NULL AND t.expires_at > NOW()
+			  ORDER BY t.last_used_at DESC NULLS LAST, t.created_at DESC`
+
+	return queryAPITokens(db, ctx, query)
+}
+
+func queryAPITokens(db *pgxpool.Pool, ctx context.Context, query string, args ...interface{}) ([]models.APIToken, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Println("Ошибка получения API токенов:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var token models.APIToken
+		err := rows.Scan(&token.ID, &token.UserID, &token.UserEmail, &token.Name, &token.Prefix,
+			&token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)
+		if err != nil {
+			log.Println("Ошибка сканирования API токена:", err)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeUserAPIToken отзывает токен, только если он принадлежит пользователю
+func RevokeUserAPIToken(db *pgxpool.Pool, ctx context.Context, id int, userID int) error {
+	query := `UPDATE api_tokens SET revoked_at = NOW()
+			  WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	tag, err := db.Exec(ctx, query, id, userID)
+	if err != nil {
+		log.Println("Ошибка отзыва API токена:", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return nil
+}
+
+// RevokeAPIToken отзывает любой токен (админ-панель) и возвращает его данные для журнала
+func RevokeAPIToken(db *pgxpool.Pool, ctx context.Context, id int) (*models.APIToken, error) {
+	var token models.APIToken
+
+	query := `UPDATE api_tokens t SET revoked_at = NOW()
+			  FROM users u
+			  WHERE t.id = $1 AND t.revoked_at IS NULL AND u.id = t.user_id
+			  RETURNING t.id, t.user_id, u.email, t.name, t.token_prefix, t.scopes, t.created_at, t.expires_at, t.last_used_at`
+
+	err := db.QueryRow(ctx, query, id).Scan(&token.ID, &token.UserID, &token.UserEmail, &token.Name, &token.Prefix,
+		&token.Scopes, &token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPITokenNotFound
+		}
+		log.Println("Ошибка отзыва API токена:", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
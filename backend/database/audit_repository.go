@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func CreateAuditEntry(db *pgxpool.Pool, ctx context.Context, entry *models.AuditEntry) error {
+	query := `INSERT INTO audit_log (actor_id, actor_email, action, entity_type, entity_id, before, after, ip_address)
+			  VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, '')::jsonb, NULLIF($7, '')::jsonb, $8)`
+
+	_, err := db.Exec(ctx, query, entry.ActorID, entry.ActorEmail, entry.Action, entry.EntityType,
+		entry.EntityID, entry.Before, entry.After, entry.IPAddress)
+	if err != nil {
+		log.Println("Ошибка записи в журнал действий:", err)
+	}
+	return err
+}
+
+// auditFilterConditions собирает условия WHERE по фильтрам журнала
+func auditFilterConditions(filter *models.AuditFilter) (string, []interface{}) {
+	conditions := " WHERE 1=1"
+	args := []interface{}{}
+
+	if filter.Actor != "" {
+		args = append(args, "%"+filter.Actor+"%")
+		conditions += fmt.Sprintf(" AND actor_email ILIKE $%d", len(args))
+	}
+
+	if filter.Action != "" {
+		args = append(args, filter.Action)
+		conditions += fmt.Sprintf(" AND action = $%d", len(args))
+	}
+
+	if filter.EntityType != "" {
+		args = append(args, filter.EntityType)
+		conditions += fmt.Sprintf(" AND entity_type = $%d", len(args))
+	}
+
+	if filter.EntityID != "" {
+		args = append(args, filter.EntityID)
+		conditions += fmt.Sprintf(" AND entity_id = $%d", len(args))
+	}
+
+	if from, err := time.ParseInLocation("2006-01-02", filter.From, time.Local); err == nil {
+		args = append(args, from)
+		conditions += fmt.Sprintf(" AND created_at >= $%d", len(args))
+	}
+
+	// Дата "по" включительно: берем начало следующего дня
+	if to, err := time.ParseInLocation("2006-01-02", filter.To, time.Local); err == nil {
+		args = append(args, to.AddDate(0, 0, 1))
+		conditions += fmt.Sprintf(" AND created_at < $%d", len(args))
+	}
+
+	return conditions, args
+}
+
+// GetAuditEntries возвращает записи журнала по фильтрам, новые сначала
+func GetAuditEntries(db *pgxpool.Pool, ctx context.Context, filter *models.AuditFilter) ([]models.AuditEntry, error) {
+	query := `SELECT id, actor_id, actor_email, action, entity_type, COALESCE(entity_id, ''),
+					 COALESCE(before::text, ''), COALESCE(after::text, ''), COALESCE(ip_address, ''), created_at
+			  FROM audit_log`
+
+	conditions, args := auditFilterConditions(filter)
+	query += conditions + " ORDER BY created_at DESC, id DESC"
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		log.Println("Ошибка получения журнала действий:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorEmail, &entry.Action, &entry.EntityType,
+			&entry.EntityID, &entry.Before, &entry.After, &entry.IPAddress, &entry.CreatedAt)
+		if err != nil {
+			log.Println("Ошибка сканирования журнала действий:", err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ExportAuditToCSV записывает в CSV все записи журнала, подходящие под фильтры
+func ExportAuditToCSV(db *pgxpool.Pool, ctx context.Context, filter *models.AuditFilter, writer *csv.Writer) error {
+	entries, err := GetAuditEntries(db, ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		actorID := ""
+		if entry.ActorID != nil {
+			actorID = strconv.Itoa(*entry.ActorID)
+		}
+
+		record := []string{
+			strconv.FormatInt(entry.ID, 10),
+			entry.CreatedAt.Format(time.RFC3339),
+			actorID,
+			entry.ActorEmail,
+			entry.Action,
+			entry.EntityType,
+			entry.EntityID,
+			entry.Before,
+			entry.After,
+			entry.IPAddress,
+		}
+
+		if err := writer.Write(record); err != nil {
+			log.Println("Ошибка записи строки CSV:", err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 
@@ -10,24 +11,37 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var (
+	// ErrVariantRequired - у товара есть варианты, а вариант не выбран
+	ErrVariantRequired = errors.New("product variant required")
+	// ErrVariantNotFound - вариант не найден или относится к другому товару
+	ErrVariantNotFound = errors.New("product variant not found")
+)
+
+// GetUserCart - позиции корзины; для варианта цена и изображение берутся из него
 func GetUserCart(db *pgxpool.Pool, ctx context.Context, cart_id int) ([]models.CartItem, error) {
 	query := `
         SELECT 
             cart_items.product_id, 
+            coalesce(cart_items.variant_id, 0),
+            coalesce(product_variants.flavor, ''),
+            coalesce(product_variants.size, ''),
             cart_items.quantity, 
             products.name, 
             products.description, 
-            products.price, 
-            products.image_url,
+            coalesce(product_variants.price, products.price), 
+            coalesce(product_variants.image_url, products.image_url),
             categories.name as category_name,
             producers.name as producer_name
         FROM cart_items
         INNER JOIN products ON products.product_id = cart_items.product_id
+        LEFT JOIN product_variants ON product_variants.variant_id = cart_items.variant_id
         INNER JOIN products_categories ON products.product_id = products_categories.product_id
         INNER JOIN categories ON categories.category_id = products_categories.category_id
         INNER JOIN products_producers ON products.product_id = products_producers.product_id
         INNER JOIN producers ON producers.producer_id = products_producers.producer_id
-        WHERE cart_items.cart_id = $1`
+        WHERE cart_items.cart_id = $1
+        ORDER BY cart_items.product_id, product_variants.position, cart_items.variant_id`
 
 	var spisok []models.CartItem
 	rows, err := db.Query(ctx, query, cart_id)
@@ -39,8 +53,12 @@ func GetUserCart(db *pgxpool.Pool, ctx context.Context, cart_id int) ([]models.C
 	for rows.Next() {
 
 		var stroka models.CartItem
+		var flavor, size string
 		err = rows.Scan(
 			&stroka.ProductID,
+			&stroka.VariantID,
+			&flavor,
+			&size,
 			&stroka.Quantity,
 			&stroka.ProductName,
 			&stroka.Description,
@@ -54,6 +72,7 @@ func GetUserCart(db *pgxpool.Pool, ctx context.Context, cart_id int) ([]models.C
 			log.Println("Ошибка сканирования:", err)
 			continue
 		}
+		stroka.VariantLabel = models.VariantLabel(flavor, size)
 		spisok = append(spisok, stroka)
 	}
 	return spisok, nil
@@ -85,21 +104,52 @@ func CreateCartForUser(db *pgxpool.Pool, ctx context.Context, userID int) (int,
 	err := db.QueryRow(ctx, query, userID).Scan(&cartID)
 	return cartID, err
 }
-func AddProductToCart(db *pgxpool.Pool, ctx context.Context, cartID int, productID int, quantity int) error {
+
+// CheckCartVariant проверяет, что вариант подходит товару: у товара с
+// вариантами он обязателен, у товара без вариантов должен быть 0
+func CheckCartVariant(db *pgxpool.Pool, ctx context.Context, productID int, variantID int) error {
+	query := `
+        SELECT
+            EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1),
+            EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1 AND variant_id = $2)`
+
+	var hasVariants, variantFound bool
+	err := db.QueryRow(ctx, query, productID, variantID).Scan(&hasVariants, &variantFound)
+	if err != nil {
+		log.Println("Ошибка проверки варианта товара:", err)
+		return err
+	}
+
+	if variantID == 0 {
+		if hasVariants {
+			return ErrVariantRequired
+		}
+		return nil
+	}
+	if !variantFound {
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+// AddProductToCart добавляет товар в корзину; variantID 0 - товар без вариантов
+func AddProductToCart(db *pgxpool.Pool, ctx context.Context, cartID int, productID int, variantID int, quantity int) error {
 
 	query :=
-		`INSERT INTO cart_items(cart_id, product_id, quantity)
-	VALUES ($1, $2, $3) ON CONFLICT (cart_id, product_id) 
+		`INSERT INTO cart_items(cart_id, product_id, variant_id, quantity)
+	VALUES ($1, $2, NULLIF($3, 0), $4) ON CONFLICT (cart_id, product_id, variant_id) 
 	DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity`
 
-	_, err := db.Exec(ctx, query, cartID, productID, quantity)
+	_, err := db.Exec(ctx, query, cartID, productID, variantID, quantity)
 	return err
 
 }
 
-func DeleteProductFromCart(db *pgxpool.Pool, ctx context.Context, cartID int, productID int) error {
-	query := `DELETE FROM cart_items WHERE product_id = $1`
+// DeleteProductFromCart удаляет позицию из корзины пользователя
+func DeleteProductFromCart(db *pgxpool.Pool, ctx context.Context, cartID int, productID int, variantID int) error {
+	query := `DELETE FROM cart_items
+	WHERE cart_id = $1 AND product_id = $2 AND variant_id IS NOT DISTINCT FROM NULLIF($3, 0)`
 
-	_, err := db.Exec(ctx, query, productID)
+	_, err := db.Exec(ctx, query, cartID, productID, variantID)
 	return err
 }
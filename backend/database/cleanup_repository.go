@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PurgeExpiredAuthData удаляет истекшие коды подтверждения, токены и сессии
+func PurgeExpiredAuthData(db *pgxpool.Pool, ctx context.Context) error {
+	queries := []struct {
+		table string
+		query string
+	}{
+		{"pending_registrations", `DELETE FROM pending_registrations WHERE expires_at < NOW()`},
+		{"forgot_password_date", `DELETE FROM forgot_password_date WHERE expires_at < NOW()`},
+		{"email_change_requests", `DELETE FROM email_change_requests WHERE expires_at < NOW()`},
+		{"password_reset_tokens", `DELETE FROM password_reset_tokens WHERE expires_at < NOW()`},
+		{"magic_link_tokens", `DELETE FROM magic_link_tokens WHERE expires_at < NOW()`},
+		{"refresh_tokens", `DELETE FROM refresh_tokens WHERE expires_at < NOW()`},
+		{"api_tokens", `DELETE FROM api_tokens
+						WHERE expires_at < NOW() OR revoked_at < NOW() - INTERVAL '1 day'`},
+		{"login_throttle", `DELETE FROM login_throttle
+							WHERE last_failure_at < NOW() - INTERVAL '1 day'
+							AND (locked_until IS NULL OR locked_until < NOW())`},
+		// Отозванные сессии храним сутки, чтобы повторное предъявление
+		// refresh токена успело попасть в лог как кража
+		{"sessions", `DELETE FROM sessions
+					  WHERE expires_at < NOW() OR revoked_at < NOW() - INTERVAL '1 day'`},
+	}
+
+	for _, q := range queries {
+		tag, err := db.Exec(ctx, q.query)
+		if err != nil {
+			log.Printf("Ошибка очистки таблицы %s: %v", q.table, err)
+			return err
+		}
+
+		if tag.RowsAffected() > 0 {
+			log.Printf("Очистка %s: удалено записей %d", q.table, tag.RowsAffected())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrEmailTaken возвращается, если новый email уже занят другим аккаунтом
+var ErrEmailTaken = errors.New("email already taken")
+
+// CreateEmailChangeRequest сохраняет запрос на смену email (один на пользователя).
+// Повторный запрос заменяет предыдущий, но не чаще cooldown.
+func CreateEmailChangeRequest(db *pgxpool.Pool, ctx context.Context, userID int, newEmail string,
+	code int, expiresAt time.Time, cooldown time.Duration) error {
+
+	query := `INSERT INTO email_change_requests (user_id, new_email, verification_code, expires_at)
+			  VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (user_id) DO UPDATE
+			  SET new_email = EXCLUDED.new_email, verification_code = EXCLUDED.verification_code,
+				  expires_at = EXCLUDED.expires_at, attempts = 0, created_at = NOW(), last_sent_at = NOW()
+			  WHERE email_change_requests.last_sent_at <= NOW() - make_interval(secs => $5)`
+
+	tag, err := db.Exec(ctx, query, userID, newEmail, code, expiresAt, cooldown.Seconds())
+	if err != nil {
+		log.Println("Ошибка создания запроса на смену email:", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrCodeCooldown
+	}
+
+	return nil
+}
+
+func GetEmailChangeRequest(db *pgxpool.Pool, ctx context.Context, userID int) (*models.EmailChangeRequest, error) {
+	var request models.EmailChangeRequest
+
+	query := `SELECT user_id, new_email, verification_code, attempts, expires_at, last_sent_at
+			  FROM email_change_requests WHERE user_id = $1`
+
+	err := db.QueryRow(ctx, query, userID).Scan(&request.UserID, &request.NewEmail, &request.VerificationCode,
+		&request.Attempts, &request.ExpiresAt, &request.LastSentAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCodeNotFound
+		}
+		log.Println("Ошибка получения запроса на смену email:", err)
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+// UseEmailChangeCodeAttempt атомарно засчитывает попытку ввода кода смены email.
+// Истекший или заблокированный код не проверяется.
+func UseEmailChangeCodeAttempt(db *pgxpool.Pool, ctx context.Context, userID int, maxAttempts int) (*models.EmailChangeRequest, error) {
+	var request models.EmailChangeRequest
+
+	query := `UPDATE email_change_requests SET attempts = attempts + 1
+			  WHERE user_id = $1
+			  RETURNING user_id, new_email, verification_code, attempts, expires_at, last_sent_at`
+
+	err := db.QueryRow(ctx, query, userID).Scan(&request.UserID, &request.NewEmail, &request.VerificationCode,
+		&request.Attempts, &request.ExpiresAt, &request.LastSentAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCodeNotFound
+		}
+		log.Println("Ошибка проверки кода смены email:", err)
+		return nil, err
+	}
+
+	if time.Now().After(request.ExpiresAt) {
+		return nil, ErrCodeExpired
+	}
+
+	if request.Attempts > maxAttempts {
+		return nil, ErrCodeLocked
+	}
+
+	return &request, nil
+}
+
+// RenewEmailChangeCode выдает новый код смены email с учетом cooldown
+func RenewEmailChangeCode(db *pgxpool.Pool, ctx context.Context, userID int, code int, expiresAt time.Time, cooldown time.Duration) error {
+	query := `UPDATE email_change_requests
+			  SET verification_code = $2, expires_at = $3, attempts = 0, last_sent_at = NOW()
+			  WHERE user_id = $1 AND last_sent_at <= NOW() - make_interval(secs => $4)`
+
+	tag, err := db.Exec(ctx, query, userID, code, expiresAt, cooldown.Seconds())
+	if err != nil {
+		log.Println("Ошибка обновления кода смены email:", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		if _, err := GetEmailChangeRequest(db, ctx, userID); err != nil {
+			return ErrCodeNotFound
+		}
+		return ErrCodeCooldown
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange переносит подтвержденный email в users и удаляет запрос.
+// Возвращает прежний и новый адреса.
+func ConfirmEmailChange(db *pgxpool.Pool, ctx context.Context, userID int) (string, string, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return "", "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var newEmail string
+	queryDelete := `DELETE FROM email_change_requests WHERE user_id = $1 RETURNING new_email`
+	err = tx.QueryRow(ctx, queryDelete, userID).Scan(&newEmail)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", ErrCodeNotFound
+		}
+		log.Println("Ошибка удаления запроса на смену email:", err)
+		return "", "", err
+	}
+
+	var oldEmail string
+	querySelect := `SELECT email FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(ctx, querySelect, userID).Scan(&oldEmail); err != nil {
+		log.Println("Ошибка получения пользователя:", err)
+		return "", "", err
+	}
+
+	queryUpdate := `UPDATE users SET email = $1 WHERE id = $2`
+	_, err = tx.Exec(ctx, queryUpdate, newEmail, userID)
+	if err != nil {
+		// Адрес мог занять другой аккаунт, пока код ждал подтверждения
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return "", "", ErrEmailTaken
+		}
+		log.Println("Ошибка смены email:", err)
+		return "", "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+		return "", "", err
+	}
+
+	return oldEmail, newEmail, nil
+}
+
+func DeleteEmailChangeRequest(db *pgxpool.Pool, ctx context.Context, userID int) error {
+	query := `DELETE FROM email_change_requests WHERE user_id = $1`
+	_, err := db.Exec(ctx, query, userID)
+	return err
+}
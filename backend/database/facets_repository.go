@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetCatalogFacets считает фильтры каталога для текущих параметров: товары
+// по категориям и производителям, по диапазонам цен и в наличии. Для каждого
+// фильтра его собственное условие снимается, остальные (и поиск) действуют.
+// priceBounds - возрастающие границы гистограммы цен, например 50, 100, 200.
+func GetCatalogFacets(db *pgxpool.Pool, ctx context.Context, params *models.FilterParams, priceBounds []float64) (*models.CatalogFacets, error) {
+	facets := &models.CatalogFacets{}
+
+	withoutCategories := *params
+	withoutCategories.Categories = nil
+	categories, err := countFacet(db, ctx, "products_categories.category_id", &withoutCategories, nil)
+	if err != nil {
+		log.Println("Ошибка подсчета товаров по категориям:", err)
+		return nil, err
+	}
+	facets.Categories = categories
+
+	withoutProducers := *params
+	withoutProducers.Producers = nil
+	producers, err := countFacet(db, ctx, "products_producers.producer_id", &withoutProducers, nil)
+	if err != nil {
+		log.Println("Ошибка подсчета товаров по производителям:", err)
+		return nil, err
+	}
+	facets.Producers = producers
+
+	// width_bucket: 0 - дешевле первой границы, len(priceBounds) - от последней
+	withoutPrice := *params
+	withoutPrice.MinPrice, withoutPrice.MaxPrice = 0, 0
+	buckets, err := countFacet(db, ctx, "width_bucket(products.price::float8, $%d::float8[])", &withoutPrice, priceBounds)
+	if err != nil {
+		log.Println("Ошибка подсчета товаров по ценам:", err)
+		return nil, err
+	}
+	for i := 0; i <= len(priceBounds); i++ {
+		bucket := models.PriceBucket{Count: buckets[i]}
+		if i > 0 {
+			bucket.Min = priceBounds[i-1]
+		}
+		if i < len(priceBounds) {
+			bucket.Max = priceBounds[i]
+		}
+		facets.PriceBuckets = append(facets.PriceBuckets, bucket)
+	}
+
+	withoutStock := *params
+	withoutStock.InStockOnly = false
+	conditions, args := productFilterConditions(&withoutStock)
+	query := `SELECT COUNT(*) FILTER (WHERE products.stock_qty > 0)` + catalogProductJoins + conditions
+	if err := db.QueryRow(ctx, query, args...).Scan(&facets.InStock); err != nil {
+		log.Println("Ошибка подсчета товаров в наличии:", err)
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+// countFacet группирует подходящие под params товары по выражению groupBy.
+// Если передан extraArg, он добавляется последним параметром, а его номер
+// подставляется в groupBy вместо %d.
+func countFacet(db *pgxpool.Pool, ctx context.Context, groupBy string, params *models.FilterParams, extraArg interface{}) (map[int]int, error) {
+	conditions, args := productFilterConditions(params)
+	if extraArg != nil {
+		args = append(args, extraArg)
+		groupBy = fmt.Sprintf(groupBy, len(args))
+	}
+
+	query := `SELECT ` + groupBy + `, COUNT(*)` + catalogProductJoins + conditions + ` GROUP BY 1`
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var key, count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, err
+		}
+		counts[key] = count
+	}
+
+	return counts, rows.Err()
+}
@@ -2,22 +2,24 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 
 	"online_store/models"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-/*
-GetFilteredProducts выполняет сложный запрос к базе данных для получения товаров
-с применением множественных фильтров и сортировки. Метод динамически строит SQL-запрос
-на основе переданных параметров.
-*/
-func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.FilterParams) ([]models.ProductForFilter, error) {
-	query := `
+// ErrProductNotFound возвращается, если товара с таким ID нет
+var ErrProductNotFound = errors.New("product not found")
+
+// Товар с категорией и производителем, как он показывается в каталоге.
+// Колонки отделены от FROM: поиск добавляет к ним подсветку, а подсчет берет только FROM.
+const (
+	catalogProductColumns = `
         SELECT 
         products.product_id, 
         products.name, 
@@ -27,7 +29,10 @@ func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.F
         products_producers.producer_id,
         categories.name as category_name,
         producers.name as producer_name,
-        products.image_url -- Добавьте эту строку
+        products.image_url,
+        products.slug,
+        (SELECT COUNT(*) FROM product_variants WHERE product_variants.product_id = products.product_id) AS variant_count`
+	catalogProductJoins = `
     FROM products
     JOIN products_categories ON products.product_id = products_categories.product_id
     JOIN products_producers ON products.product_id = products_producers.product_id
@@ -36,6 +41,28 @@ func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.F
     WHERE 1=1 
     ` // "WHERE 1=1" - точка начала для удобного добавления условий через AND
 
+	catalogProductQuery = catalogProductColumns + catalogProductJoins
+)
+
+// Настройки ts_headline: название подсвечивается целиком,
+// из описания берутся до двух фрагментов вокруг найденных слов
+var (
+	nameHeadlineOptions = fmt.Sprintf("HighlightAll=true, StartSel=%s, StopSel=%s",
+		models.HighlightStart, models.HighlightEnd)
+	snippetHeadlineOptions = fmt.Sprintf(`MaxWords=30, MinWords=12, MaxFragments=2, FragmentDelimiter=" … ", StartSel=%s, StopSel=%s`,
+		models.HighlightStart, models.HighlightEnd)
+)
+
+// searchTSQuery - поисковый запрос в русской и английской конфигурациях:
+// "протеина" находит "протеин", "bars" - "bar"
+func searchTSQuery(param int) string {
+	return fmt.Sprintf("(websearch_to_tsquery('russian', $%[1]d) || websearch_to_tsquery('english', $%[1]d))", param)
+}
+
+// productFilterConditions строит условия WHERE по фильтрам каталога.
+// Используется и выборкой страницы, и подсчетом общего количества.
+func productFilterConditions(params *models.FilterParams) (string, []interface{}) {
+	conditions := ""
 	args := []interface{}{}
 	paramCount := 0
 
@@ -47,7 +74,7 @@ func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.F
 			args = append(args, catID)
 			placeholders[i] = fmt.Sprintf("$%d", paramCount+i)
 		}
-		query += fmt.Sprintf(" AND products_categories.category_id IN (%s)", strings.Join(placeholders, ","))
+		conditions += fmt.Sprintf(" AND products_categories.category_id IN (%s)", strings.Join(placeholders, ","))
 		paramCount += len(params.Categories) - 1
 	}
 
@@ -59,40 +86,58 @@ func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.F
 			args = append(args, prodID)
 			placeholders[i] = fmt.Sprintf("$%d", paramCount+i)
 		}
-		query += fmt.Sprintf(" AND products_producers.producer_id IN (%s)", strings.Join(placeholders, ","))
+		conditions += fmt.Sprintf(" AND products_producers.producer_id IN (%s)", strings.Join(placeholders, ","))
 		paramCount += len(params.Producers) - 1
 	}
 
 	// Фильтр по максимальной цене
 	if params.MaxPrice > 0 {
 		paramCount++
-		query += fmt.Sprintf(" AND products.price <= $%d", paramCount)
+		conditions += fmt.Sprintf(" AND products.price <= $%d", paramCount)
 		args = append(args, params.MaxPrice)
 	}
 
 	// Фильтр по минимальной цене
 	if params.MinPrice > 0 {
 		paramCount++
-		query += fmt.Sprintf(" AND products.price >= $%d", paramCount)
+		conditions += fmt.Sprintf(" AND products.price >= $%d", paramCount)
 		args = append(args, params.MinPrice)
 	}
 
-	if params.SearchQuery != "" {
-		paramCount++
-		query += fmt.Sprintf(" AND products.name ILIKE $%d", paramCount)
-		args = append(args, "%"+params.SearchQuery+"%")
+	// Только товары в наличии
+	if params.InStockOnly {
+		conditions += " AND products.stock_qty > 0"
 	}
 
-	switch params.SortByPrice {
-	case "ASC":
-		query += " ORDER BY products.price ASC"
-	case "DESC":
-		query += " ORDER BY products.price DESC"
-	default:
-		// Сортировка по умолчанию (опционально)
-		query += " ORDER BY products.product_id ASC"
+	// Полнотекстовый поиск по названию, производителю, категории и описанию.
+	// ILIKE по названию оставлен для недописанных слов ("проте").
+	// Fuzzy - запасной поиск с опечатками: слово запроса похоже
+	// на часть названия товара, производителя или категории
+	if params.SearchQuery != "" && params.Fuzzy {
+		paramCount++
+		conditions += fmt.Sprintf(" AND ($%[1]d <%% products.name OR $%[1]d <%% producers.name OR $%[1]d <%% categories.name)",
+			paramCount)
+		args = append(args, params.SearchQuery)
+	} else if params.SearchQuery != "" {
+		paramCount++
+		conditions += fmt.Sprintf(" AND (products.search_vector @@ %s OR products.name ILIKE $%d)",
+			searchTSQuery(paramCount), paramCount+1)
+		args = append(args, params.SearchQuery, "%"+params.SearchQuery+"%")
+		paramCount++
 	}
 
+	return conditions, args
+}
+
+/*
+GetFilteredProducts выполняет сложный запрос к базе данных для получения товаров
+с применением множественных фильтров и сортировки. Метод динамически строит SQL-запрос
+на основе переданных параметров. При заданном PageSize возвращает одну страницу,
+общее количество считает CountFilteredProducts.
+*/
+func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.FilterParams) ([]models.ProductForFilter, error) {
+	query, args := filteredProductsQuery(params)
+
 	// Выполняем запрос
 	rows, err := db.Query(ctx, query, args...)
 	if err != nil {
@@ -115,6 +160,10 @@ func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.F
 			&product.CategoryName,
 			&product.ProducerName,
 			&product.Image, // Добавьте это
+			&product.Slug,
+			&product.VariantCount,
+			&product.NameHighlight,
+			&product.Snippet,
 		)
 		if err != nil {
 			log.Println("Ошибка сканирования продукта:", err)
@@ -130,6 +179,98 @@ func GetFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.F
 	return products, nil
 }
 
+// filteredProductsQuery собирает запрос страницы каталога: фильтры, подсветка
+// и релевантность при поиске, сортировка и LIMIT/OFFSET
+func filteredProductsQuery(params *models.FilterParams) (string, []interface{}) {
+	conditions, args := productFilterConditions(params)
+
+	// Подсветка и релевантность нужны только при поиске
+	highlightColumns := `,
+        '' AS name_highlight,
+        '' AS snippet`
+	rankOrder := ""
+
+	if params.SearchQuery != "" && params.Fuzzy {
+		args = append(args, params.SearchQuery)
+		rankOrder = fmt.Sprintf(`greatest(word_similarity($%[1]d, products.name),
+            word_similarity($%[1]d, producers.name), word_similarity($%[1]d, categories.name)) DESC, `, len(args))
+	} else if params.SearchQuery != "" {
+		args = append(args, params.SearchQuery)
+		tsQuery := searchTSQuery(len(args))
+
+		highlightColumns = fmt.Sprintf(`,
+        ts_headline('russian', products.name, %[1]s, '%[2]s') AS name_highlight,
+        ts_headline('russian', coalesce(products.description, ''), %[1]s, '%[3]s') AS snippet`,
+			tsQuery, nameHeadlineOptions, snippetHeadlineOptions)
+		rankOrder = fmt.Sprintf("ts_rank(products.search_vector, %s) DESC, ", tsQuery)
+	}
+
+	query := catalogProductColumns + highlightColumns + catalogProductJoins + conditions
+
+	// product_id в конце делает порядок однозначным, иначе товары
+	// с одинаковой ценой могли бы переезжать между страницами
+	switch params.SortByPrice {
+	case "ASC":
+		query += " ORDER BY products.price ASC, products.product_id ASC"
+	case "DESC":
+		query += " ORDER BY products.price DESC, products.product_id ASC"
+	default:
+		// Без сортировки по цене: при поиске сначала самые релевантные
+		query += " ORDER BY " + rankOrder + "products.product_id ASC"
+	}
+
+	if params.PageSize > 0 {
+		page := max(params.Page, 1)
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", params.PageSize, (page-1)*params.PageSize)
+	}
+
+	return query, args
+}
+
+// CountFilteredProducts - сколько всего товаров подходит под фильтры (без учета страницы)
+func CountFilteredProducts(db *pgxpool.Pool, ctx context.Context, params *models.FilterParams) (int, error) {
+	conditions, args := productFilterConditions(params)
+
+	query := `SELECT COUNT(*)` + catalogProductJoins + conditions
+
+	var total int
+	err := db.QueryRow(ctx, query, args...).Scan(&total)
+	if err != nil {
+		log.Println("Ошибка подсчета товаров:", err)
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// GetCatalogProduct - один товар в том же виде, что и в каталоге
+func GetCatalogProduct(db *pgxpool.Pool, ctx context.Context, productID int) (*models.ProductForFilter, error) {
+	var product models.ProductForFilter
+
+	err := db.QueryRow(ctx, catalogProductQuery+" AND products.product_id = $1", productID).Scan(
+		&product.ProductID,
+		&product.Name,
+		&product.Price,
+		&product.StockQuantity,
+		&product.CategoryID,
+		&product.ProducerID,
+		&product.CategoryName,
+		&product.ProducerName,
+		&product.Image,
+		&product.Slug,
+		&product.VariantCount,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		log.Println("Ошибка получения товара:", err)
+		return nil, err
+	}
+
+	return &product, nil
+}
+
 // GetCategories получает все категории из БД
 func GetCategories(db *pgxpool.Pool, ctx context.Context) ([]models.Category, error) {
 	query := `SELECT category_id, name FROM categories ORDER BY name`
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrIdentityNotFound возвращается, если внешний аккаунт еще не привязан
+var ErrIdentityNotFound = errors.New("identity not found")
+
+// GetIdentityUserID находит пользователя по привязанному внешнему аккаунту
+// и отмечает время входа
+func GetIdentityUserID(db *pgxpool.Pool, ctx context.Context, provider string, subject string) (int, error) {
+	var userID int
+
+	query := `UPDATE user_identities SET last_login_at = NOW()
+			  WHERE provider = $1 AND subject = $2
+			  RETURNING user_id`
+
+	err := db.QueryRow(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrIdentityNotFound
+		}
+		log.Println("Ошибка поиска внешнего аккаунта:", err)
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// LinkIdentity привязывает внешний аккаунт к существующему пользователю
+func LinkIdentity(db *pgxpool.Pool, ctx context.Context, identity *models.UserIdentity) error {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email)
+			  VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (provider, subject) DO NOTHING`
+
+	_, err := db.Exec(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		log.Println("Ошибка привязки внешнего аккаунта:", err)
+	}
+	return err
+}
+
+// CreateUserWithIdentity создает пользователя без пароля, вошедшего через
+// внешний провайдер, и сразу привязывает к нему внешний аккаунт
+func CreateUserWithIdentity(db *pgxpool.Pool, ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO users (email, password, name, account_state) VALUES ($1, $2, $3, $4) RETURNING id`
+	err = tx.QueryRow(ctx, query, user.Email, user.Password, user.Name, user.AccountState).Scan(&user.ID)
+	if err != nil {
+		log.Println("Ошибка создания пользователя:", err)
+		return err
+	}
+
+	identity.UserID = user.ID
+	queryIdentity := `INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(ctx, queryIdentity, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		log.Println("Ошибка привязки внешнего аккаунта:", err)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetLoginThrottle возвращает счетчик неудачных входов. Если записи нет,
+// возвращается пустой счетчик без ошибки.
+func GetLoginThrottle(db *pgxpool.Pool, ctx context.Context, scope string, subject string) (*models.LoginThrottle, error) {
+	throttle := models.LoginThrottle{Scope: scope, Subject: subject}
+
+	query := `SELECT failures, last_failure_at, locked_until, COALESCE(locked_until > NOW(), false)
+			  FROM login_throttle
+			  WHERE scope = $1 AND subject = $2`
+
+	err := db.QueryRow(ctx, query, scope, subject).Scan(&throttle.Failures, &throttle.LastFailureAt,
+		&throttle.LockedUntil, &throttle.Locked)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &throttle, nil
+		}
+		log.Println("Ошибка получения счетчика входов:", err)
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+// RecordLoginFailure увеличивает счетчик неудачных входов. Если с прошлой
+// неудачи прошло больше window, счет начинается заново. При достижении
+// lockThreshold субъект блокируется на lockFor.
+func RecordLoginFailure(db *pgxpool.Pool, ctx context.Context, scope string, subject string,
+	window time.Duration, lockThreshold int, lockFor time.Duration) (*models.LoginThrottle, error) {
+
+	throttle := models.LoginThrottle{Scope: scope, Subject: subject}
+
+	query := `INSERT INTO login_throttle (scope, subject, failures, last_failure_at)
+			  VALUES ($1, $2, 1, NOW())
+			  ON CONFLICT (scope, subject) DO UPDATE SET
+				  failures = CASE
+					  WHEN login_throttle.last_failure_at < NOW() - make_interval(secs => $3)
+						   AND (login_throttle.locked_until IS NULL OR login_throttle.locked_until < NOW())
+					  THEN 1
+					  ELSE login_throttle.failures + 1
+				  END,
+				  last_failure_at = NOW()
+			  RETURNING failures, last_failure_at`
+
+	err := db.QueryRow(ctx, query, scope, subject, window.Seconds()).Scan(&throttle.Failures, &throttle.LastFailureAt)
+	if err != nil {
+		log.Println("Ошибка записи неудачного входа:", err)
+		return nil, err
+	}
+
+	if throttle.Failures >= lockThreshold {
+		lockedUntil := time.Now().Add(lockFor)
+
+		_, err = db.Exec(ctx, `UPDATE login_throttle SET locked_until = $3 WHERE scope = $1 AND subject = $2`,
+			scope, subject, lockedUntil)
+		if err != nil {
+			log.Println("Ошибка блокировки входа:", err)
+			return nil, err
+		}
+
+		throttle.LockedUntil = &lockedUntil
+		throttle.Locked = true
+	}
+
+	return &throttle, nil
+}
+
+// ClearLoginThrottle сбрасывает счетчик (успешный вход или решение администратора)
+func ClearLoginThrottle(db *pgxpool.Pool, ctx context.Context, scope string, subject string) error {
+	query := `DELETE FROM login_throttle WHERE scope = $1 AND subject = $2`
+	_, err := db.Exec(ctx, query, scope, subject)
+	return err
+}
+
+// GetLoginThrottles возвращает все счетчики для админ-панели: сначала активные блокировки
+func GetLoginThrottles(db *pgxpool.Pool, ctx context.Context) ([]models.LoginThrottle, error) {
+	query := `SELECT scope, subject, failures, last_failure_at, locked_until, COALESCE(locked_until > NOW(), false) AS locked
+			  FROM login_throttle
+			  ORDER BY locked DESC, last_failure_at DESC`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		log.Println("Ошибка получения блокировок входа:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var throttles []models.LoginThrottle
+	for rows.Next() {
+		var throttle models.LoginThrottle
+		err := rows.Scan(&throttle.Scope, &throttle.Subject, &throttle.Failures,
+			&throttle.LastFailureAt, &throttle.LockedUntil, &throttle.Locked)
+		if err != nil {
+			log.Println("Ошибка сканирования блокировки входа:", err)
+			return nil, err
+		}
+		throttles = append(throttles, throttle)
+	}
+
+	return throttles, rows.Err()
+}
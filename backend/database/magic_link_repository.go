@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrMagicLinkInvalid возвращается для неизвестной, истекшей или уже использованной ссылки
+var ErrMagicLinkInvalid = errors.New("magic link invalid")
+
+// CreateMagicLinkToken сохраняет хеш ссылки для входа.
+// Новая ссылка выдается не чаще раза в cooldown.
+func CreateMagicLinkToken(db *pgxpool.Pool, ctx context.Context, userID int, tokenHash string,
+	expiresAt time.Time, cooldown time.Duration) error {
+
+	query := `INSERT INTO magic_link_tokens (token_hash, user_id, expires_at)
+			  SELECT $1, $2, $3
+			  WHERE NOT EXISTS (
+				  SELECT 1 FROM magic_link_tokens
+				  WHERE user_id = $2 AND created_at > NOW() - make_interval(secs => $4)
+			  )`
+
+	tag, err := db.Exec(ctx, query, tokenHash, userID, expiresAt, cooldown.Seconds())
+	if err != nil {
+		log.Println("Ошибка сохранения ссылки для входа:", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrCodeCooldown
+	}
+
+	return nil
+}
+
+// GetMagicLinkTokenUser проверяет ссылку, не используя ее
+func GetMagicLinkTokenUser(db *pgxpool.Pool, ctx context.Context, tokenHash string) (int, error) {
+	var userID int
+
+	query := `SELECT user_id FROM magic_link_tokens
+			  WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+
+	err := db.QueryRow(ctx, query, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrMagicLinkInvalid
+		}
+		log.Println("Ошибка проверки ссылки для входа:", err)
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// UseMagicLinkToken помечает ссылку использованной и гасит остальные ссылки
+// пользователя. Возвращает ID пользователя.
+func UseMagicLinkToken(db *pgxpool.Pool, ctx context.Context, tokenHash string) (int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	query := `UPDATE magic_link_tokens SET used_at = NOW()
+			  WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+			  RETURNING user_id`
+
+	err = tx.QueryRow(ctx, query, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrMagicLinkInvalid
+		}
+		log.Println("Ошибка использования ссылки для входа:", err)
+		return 0, err
+	}
+
+	queryBurn := `UPDATE magic_link_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`
+	_, err = tx.Exec(ctx, queryBurn, userID)
+	if err != nil {
+		log.Println("Ошибка отзыва ссылок для входа:", err)
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+		return 0, err
+	}
+
+	return userID, nil
+}
@@ -14,13 +14,21 @@ import (
 func GetOrders(db *pgxpool.Pool, ctx context.Context, UserID int) ([]models.OrdersProfile, error) {
 	var Orders []models.OrdersProfile
 
-	query := `SELECT orders.order_id, name, quantity, total_amount
+	// Вариант дописывается к названию: "Gold Standard Whey (Шоколад, 900 г)"
+	query := `SELECT orders.order_id,
+			  CASE WHEN product_variants.variant_id IS NULL THEN products.name
+			  ELSE products.name || ' (' || concat_ws(', ', product_variants.flavor, product_variants.size) || ')'
+			  END,
+			  quantity, total_amount
 			  FROM order_items
 			  JOIN orders
 			  ON orders.order_id = order_items.order_id
 			  JOIN products
 			  ON products.product_id = order_items.product_id
-			  WHERE orders.user_id = $1`
+			  LEFT JOIN product_variants
+			  ON product_variants.variant_id = order_items.variant_id
+			  WHERE orders.user_id = $1
+			  ORDER BY orders.order_id, order_items.order_item_id`
 
 	rows, err := db.Query(ctx, query, UserID)
 	if err != nil {
@@ -75,6 +83,8 @@ func CreateOrder(db *pgxpool.Pool, ctx context.Context, userID int) (int, error)
 	}
 
 	queryUpdateQtyProducts := `UPDATE products SET stock_qty = stock_qty - $1 WHERE product_id = $2`
+	// Остаток товара с вариантами пересчитывает триггер product_variants
+	queryUpdateQtyVariants := `UPDATE product_variants SET stock_qty = stock_qty - $1 WHERE variant_id = $2`
 
 	tx, err := db.BeginTx(ctx, pgx.TxOptions{
 		IsoLevel: pgx.RepeatableRead,
@@ -87,7 +97,11 @@ func CreateOrder(db *pgxpool.Pool, ctx context.Context, userID int) (int, error)
 	defer tx.Rollback(ctx)
 
 	for i, cart_item := range cart {
-		_, err = tx.Exec(ctx, queryUpdateQtyProducts, cart_item.Quantity, cart_item.ProductID)
+		if cart_item.VariantID != 0 {
+			_, err = tx.Exec(ctx, queryUpdateQtyVariants, cart_item.Quantity, cart_item.VariantID)
+		} else {
+			_, err = tx.Exec(ctx, queryUpdateQtyProducts, cart_item.Quantity, cart_item.ProductID)
+		}
 
 		if err != nil {
 			fmt.Printf("ошибка обновления количества товара на итерации %d: %s", i, err)
@@ -114,11 +128,11 @@ func CreateOrder(db *pgxpool.Pool, ctx context.Context, userID int) (int, error)
 		return 0, err
 	}
 
-	queryCreateOrderItems := `INSERT INTO order_items(order_id, product_id, quantity, unit_price)
-	VALUES ($1, $2, $3, $4) `
+	queryCreateOrderItems := `INSERT INTO order_items(order_id, product_id, variant_id, quantity, unit_price)
+	VALUES ($1, $2, NULLIF($3, 0), $4, $5) `
 
 	for _, CartItems := range cart {
-		_, err = tx.Exec(ctx, queryCreateOrderItems, orderID, CartItems.ProductID, CartItems.Quantity, CartItems.Price)
+		_, err = tx.Exec(ctx, queryCreateOrderItems, orderID, CartItems.ProductID, CartItems.VariantID, CartItems.Quantity, CartItems.Price)
 		if err != nil {
 			log.Println("ошибка заполнения таблицы order_items: ", err)
 			return 0, err
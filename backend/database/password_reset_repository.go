@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrResetTokenInvalid - токен сброса пароля не найден, истек или уже использован
+var ErrResetTokenInvalid = errors.New("reset token invalid")
+
+func CreatePasswordResetToken(db *pgxpool.Pool, ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`
+
+	_, err := db.Exec(ctx, query, tokenHash, userID, expiresAt)
+	if err != nil {
+		log.Println("Ошибка создания токена сброса пароля:", err)
+	}
+
+	return err
+}
+
+// GetPasswordResetTokenUser возвращает пользователя по действующему токену сброса
+func GetPasswordResetTokenUser(db *pgxpool.Pool, ctx context.Context, tokenHash string) (int, error) {
+	var userID int
+
+	query := `SELECT user_id FROM password_reset_tokens
+			  WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`
+
+	err := db.QueryRow(ctx, query, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrResetTokenInvalid
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ResetPasswordByToken в одной транзакции меняет пароль владельцу токена,
+// гасит все его неиспользованные токены сброса и отзывает сессии.
+// Возвращает ID пользователя.
+func ResetPasswordByToken(db *pgxpool.Pool, ctx context.Context, tokenHash string, passwordHash string) (int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+
+	// 1. Блокируем токен, чтобы его нельзя было использовать дважды параллельно
+	querySelect := `SELECT user_id FROM password_reset_tokens
+					WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+					FOR UPDATE`
+
+	err = tx.QueryRow(ctx, querySelect, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrResetTokenInvalid
+		}
+		log.Println("Ошибка получения токена сброса пароля:", err)
+		return 0, err
+	}
+
+	// 2. Меняем пароль
+	_, err = tx.Exec(ctx, `UPDATE users SET password = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		log.Println("Ошибка обновления пароля:", err)
+		return 0, err
+	}
+
+	// 3. Гасим все выданные пользователю токены сброса
+	_, err = tx.Exec(ctx, `UPDATE password_reset_tokens SET used_at = NOW()
+						   WHERE user_id = $1 AND used_at IS NULL`, userID)
+	if err != nil {
+		log.Println("Ошибка погашения токенов сброса пароля:", err)
+		return 0, err
+	}
+
+	// 4. Старые сессии не должны оставаться живыми
+	_, err = tx.Exec(ctx, `UPDATE sessions SET revoked_at = NOW()
+						   WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		log.Println("Ошибка отзыва сессий пользователя:", err)
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+		return 0, err
+	}
+
+	log.Printf("Пароль пользователя %d сброшен", userID)
+	return userID, nil
+}
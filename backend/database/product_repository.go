@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// relatedProductsLimit - сколько товаров в блоках "Еще от бренда" и "Еще в категории"
+const relatedProductsLimit = 4
+
+// GetProductDetails - страница товара по ID: описание, страна производителя,
+// галерея и похожие товары того же бренда и той же категории
+func GetProductDetails(db *pgxpool.Pool, ctx context.Context, productID int) (*models.ProductDetails, error) {
+	return getProductDetails(db, ctx, "products.product_id = $1", productID)
+}
+
+// GetProductDetailsBySlug - то же, что GetProductDetails, по slug из адреса
+func GetProductDetailsBySlug(db *pgxpool.Pool, ctx context.Context, slug string) (*models.ProductDetails, error) {
+	return getProductDetails(db, ctx, "products.slug = $1", slug)
+}
+
+func getProductDetails(db *pgxpool.Pool, ctx context.Context, condition string, arg interface{}) (*models.ProductDetails, error) {
+	// Галерея: главное изображение, затем product_images по порядку
+	query := `
+        SELECT
+        products.product_id,
+        products.slug,
+        products.name,
+        coalesce(products.description, ''),
+        products.price,
+        products.stock_qty,
+        categories.category_id,
+        categories.name,
+        producers.producer_id,
+        producers.name,
+        coalesce(producers.country, ''),
+        array_remove(ARRAY[products.image_url] || ARRAY(
+            SELECT product_images.image_url FROM product_images
+            WHERE product_images.product_id = products.product_id
+            ORDER BY product_images.position, product_images.image_id
+        ), NULL)` + catalogProductJoins + " AND " + condition
+
+	var product models.ProductDetails
+	err := db.QueryRow(ctx, query, arg).Scan(
+		&product.ProductID,
+		&product.Slug,
+		&product.Name,
+		&product.Description,
+		&product.Price,
+		&product.StockQuantity,
+		&product.CategoryID,
+		&product.CategoryName,
+		&product.ProducerID,
+		&product.ProducerName,
+		&product.ProducerCountry,
+		&product.Images,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		log.Println("Ошибка получения товара:", err)
+		return nil, err
+	}
+
+	product.Variants, err = GetProductVariants(db, ctx, product.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Вкусы и фасовки для селекторов - без повторов, в порядке вариантов
+	flavors, sizes := make(models.StringSet), make(models.StringSet)
+	for _, variant := range product.Variants {
+		if variant.Flavor != "" && !flavors.Contains(variant.Flavor) {
+			flavors[variant.Flavor] = true
+			product.Flavors = append(product.Flavors, variant.Flavor)
+		}
+		if variant.Size != "" && !sizes.Contains(variant.Size) {
+			sizes[variant.Size] = true
+			product.Sizes = append(product.Sizes, variant.Size)
+		}
+	}
+
+	// Сначала товары в наличии. В "Еще в категории" - другие бренды,
+	// чтобы блоки не повторяли друг друга
+	product.MoreFromBrand, err = getRelatedProducts(db, ctx,
+		"products_producers.producer_id = $1 AND products.product_id <> $2",
+		product.ProducerID, product.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	product.MoreFromCategory, err = getRelatedProducts(db, ctx,
+		"products_categories.category_id = $1 AND products_producers.producer_id <> $2",
+		product.CategoryID, product.ProducerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+// getRelatedProducts - товары каталога по условию с параметрами $1 и $2
+func getRelatedProducts(db *pgxpool.Pool, ctx context.Context, condition string, first, second int) ([]models.ProductForFilter, error) {
+	query := catalogProductQuery + " AND " + condition +
+		" ORDER BY products.stock_qty > 0 DESC, products.product_id LIMIT $3"
+
+	rows, err := db.Query(ctx, query, first, second, relatedProductsLimit)
+	if err != nil {
+		log.Println("Ошибка получения похожих товаров:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.ProductForFilter
+	for rows.Next() {
+		var product models.ProductForFilter
+		err := rows.Scan(
+			&product.ProductID,
+			&product.Name,
+			&product.Price,
+			&product.StockQuantity,
+			&product.CategoryID,
+			&product.ProducerID,
+			&product.CategoryName,
+			&product.ProducerName,
+			&product.Image,
+			&product.Slug,
+			&product.VariantCount,
+		)
+		if err != nil {
+			log.Println("Ошибка сканирования похожего товара:", err)
+			continue
+		}
+		products = append(products, product)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return products, nil
+}
+
+// GetProductVariants - варианты товара по position; пустой список, если вариантов нет
+func GetProductVariants(db *pgxpool.Pool, ctx context.Context, productID int) ([]models.ProductVariant, error) {
+	query := `
+        SELECT
+        product_variants.variant_id,
+        product_variants.sku,
+        coalesce(product_variants.flavor, ''),
+        coalesce(product_variants.size, ''),
+        product_variants.price,
+        product_variants.stock_qty,
+        coalesce(product_variants.image_url, products.image_url, '')
+        FROM product_variants
+        JOIN products ON products.product_id = product_variants.product_id
+        WHERE product_variants.product_id = $1
+        ORDER BY product_variants.position, product_variants.variant_id`
+
+	rows, err := db.Query(ctx, query, productID)
+	if err != nil {
+		log.Println("Ошибка получения вариантов товара:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []models.ProductVariant
+	for rows.Next() {
+		var variant models.ProductVariant
+		err := rows.Scan(
+			&variant.VariantID,
+			&variant.SKU,
+			&variant.Flavor,
+			&variant.Size,
+			&variant.Price,
+			&variant.StockQuantity,
+			&variant.Image,
+		)
+		if err != nil {
+			log.Println("Ошибка сканирования варианта товара:", err)
+			continue
+		}
+		variants = append(variants, variant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return variants, nil
+}
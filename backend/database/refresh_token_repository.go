@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrRefreshTokenInvalid - токен не найден, истек или его сессия уже отозвана
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	// ErrRefreshTokenReused - предъявлен уже использованный токен, семейство отозвано
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+func CreateRefreshToken(db *pgxpool.Pool, ctx context.Context, sessionID string, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)`
+
+	_, err := db.Exec(ctx, query, tokenHash, sessionID, expiresAt)
+	if err != nil {
+		log.Println("Ошибка создания refresh токена:", err)
+	}
+
+	return err
+}
+
+// GetRefreshTokenSession возвращает сессию, к которой относится refresh токен
+func GetRefreshTokenSession(db *pgxpool.Pool, ctx context.Context, tokenHash string) (string, error) {
+	var sessionID string
+
+	query := `SELECT session_id FROM refresh_tokens WHERE token_hash = $1`
+
+	err := db.QueryRow(ctx, query, tokenHash).Scan(&sessionID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrRefreshTokenInvalid
+		}
+		return "", err
+	}
+
+	return sessionID, nil
+}
+
+// RotateRefreshToken гасит старый refresh токен и выдает вместо него новый
+// в рамках той же сессии (семейства). Срок сессии сдвигается на ttl.
+// Повторное предъявление погашенного токена означает кражу: сессия
+// отзывается целиком, и все токены семейства перестают работать.
+// Исключение - одно повторное предъявление в первые grace после погашения:
+// так второй параллельный запрос (две вкладки, перезагрузка во время fetch)
+// получает свой новый токен той же сессии. Любой следующий повтор - кража.
+// Возвращает идентификатор сессии и пользователя.
+func RotateRefreshToken(db *pgxpool.Pool, ctx context.Context, oldHash string, newHash string, ttl time.Duration, grace time.Duration) (string, int, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return "", 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID string
+	var usedAt *time.Time
+	var graceUsedAt *time.Time
+	var expiresAt time.Time
+	var withinGrace bool
+
+	// Окно считается по часам базы, как и used_at
+	querySelect := `SELECT session_id, used_at, grace_used_at, expires_at,
+					coalesce(used_at > NOW() - make_interval(secs => $2), false)
+					FROM refresh_tokens
+					WHERE token_hash = $1
+					FOR UPDATE`
+
+	err = tx.QueryRow(ctx, querySelect, oldHash, grace.Seconds()).Scan(&sessionID, &usedAt, &graceUsedAt, &expiresAt, &withinGrace)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, ErrRefreshTokenInvalid
+		}
+		log.Println("Ошибка получения refresh токена:", err)
+		return "", 0, err
+	}
+
+	// 1. Токен уже был использован вне окна grace или окно уже израсходовано -
+	// отзываем все семейство
+	if usedAt != nil && (!withinGrace || graceUsedAt != nil) {
+		_, err = tx.Exec(ctx, `UPDATE sessions SET revoked_at = NOW()
+							   WHERE session_id = $1 AND revoked_at IS NULL`, sessionID)
+		if err != nil {
+			log.Println("Ошибка отзыва сессии:", err)
+			return "", 0, err
+		}
+
+		if err = tx.Commit(ctx); err != nil {
+			log.Println("Ошибка коммита транзакции:", err)
+			return "", 0, err
+		}
+
+		log.Printf("Повторное использование refresh токена, сессия %s отозвана", sessionID)
+		return sessionID, 0, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+
+	// 2. Гасим старый токен. Повтор в окне grace время погашения не сдвигает,
+	// иначе окно продлевалось бы бесконечно, а только отмечает, что окно израсходовано
+	if usedAt == nil {
+		_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1`, oldHash)
+		if err != nil {
+			log.Println("Ошибка погашения refresh токена:", err)
+			return "", 0, err
+		}
+	} else {
+		_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET grace_used_at = NOW() WHERE token_hash = $1`, oldHash)
+		if err != nil {
+			log.Println("Ошибка погашения refresh токена:", err)
+			return "", 0, err
+		}
+		log.Printf("Повторное использование refresh токена в окне grace, сессия %s", sessionID)
+	}
+
+	// 3. Продлеваем сессию, если она еще жива. Сессия входа под
+	// пользователем не продлевается: ее срок задан при создании
+	var userID int
+	newExpiresAt := time.Now().Add(ttl)
+
+	queryExtend := `UPDATE sessions SET last_seen_at = NOW(),
+					    expires_at = CASE WHEN impersonator_id IS NULL THEN $2 ELSE expires_at END
+					WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+					RETURNING user_id`
+
+	err = tx.QueryRow(ctx, queryExtend, sessionID, newExpiresAt).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", 0, ErrRefreshTokenInvalid
+		}
+		log.Println("Ошибка продления сессии:", err)
+		return "", 0, err
+	}
+
+	// 4. Выдаем новый токен того же семейства
+	_, err = tx.Exec(ctx, `INSERT INTO refresh_tokens (token_hash, session_id, expires_at) VALUES ($1, $2, $3)`,
+		newHash, sessionID, newExpiresAt)
+	if err != nil {
+		log.Println("Ошибка создания refresh токена:", err)
+		return "", 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+		return "", 0, err
+	}
+
+	return sessionID, userID, nil
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrRoleNotFound возвращается, если роли с таким именем нет
+var ErrRoleNotFound = errors.New("role not found")
+
+// GetUserAccess возвращает роли пользователя и объединение прав всех его ролей
+func GetUserAccess(db *pgxpool.Pool, ctx context.Context, userID int) ([]string, models.StringSet, error) {
+	query := `SELECT roles.name, permissions.name
+			  FROM user_roles
+			  JOIN roles ON roles.role_id = user_roles.role_id
+			  LEFT JOIN role_permissions ON role_permissions.role_id = roles.role_id
+			  LEFT JOIN permissions ON permissions.permission_id = role_permissions.permission_id
+			  WHERE user_roles.user_id = $1`
+
+	rows, err := db.Query(ctx, query, userID)
+	if err != nil {
+		log.Println("Ошибка получения прав пользователя:", err)
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	seenRoles := make(models.StringSet)
+	permissions := make(models.StringSet)
+
+	for rows.Next() {
+		var role string
+		var permission *string
+
+		if err := rows.Scan(&role, &permission); err != nil {
+			log.Println("Ошибка сканирования прав пользователя:", err)
+			return nil, nil, err
+		}
+
+		if !seenRoles.Contains(role) {
+			seenRoles[role] = true
+			roles = append(roles, role)
+		}
+		if permission != nil {
+			permissions[*permission] = true
+		}
+	}
+
+	return roles, permissions, rows.Err()
+}
+
+func GetRoles(db *pgxpool.Pool, ctx context.Context) ([]models.Role, error) {
+	rows, err := db.Query(ctx, `SELECT role_id, name, COALESCE(description, '') FROM roles ORDER BY name`)
+	if err != nil {
+		log.Println("Ошибка получения ролей:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description); err != nil {
+			log.Println("Ошибка сканирования роли:", err)
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// GetStaffUsers возвращает пользователей, у которых есть хотя бы одна роль
+func GetStaffUsers(db *pgxpool.Pool, ctx context.Context) ([]models.StaffUser, error) {
+	query := `SELECT users.id, users.email, users.name, users.account_state,
+					 string_agg(roles.name, ', ' ORDER BY roles.name)
+			  FROM users
+			  JOIN user_roles ON user_roles.user_id = users.id
+			  JOIN roles ON roles.role_id = user_roles.role_id
+			  GROUP BY users.id
+			  ORDER BY users.id`
+
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		log.Println("Ошибка получения сотрудников:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.StaffUser
+	for rows.Next() {
+		var user models.StaffUser
+		if err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.AccountState, &user.Roles); err != nil {
+			log.Println("Ошибка сканирования сотрудника:", err)
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func AssignRole(db *pgxpool.Pool, ctx context.Context, userID int, roleName string) error {
+	var roleID int
+
+	err := db.QueryRow(ctx, `SELECT role_id FROM roles WHERE name = $1`, roleName).Scan(&roleID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	query := `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err = db.Exec(ctx, query, userID, roleID)
+	if err != nil {
+		log.Println("Ошибка назначения роли:", err)
+	}
+	return err
+}
+
+func RemoveRole(db *pgxpool.Pool, ctx context.Context, userID int, roleName string) error {
+	query := `DELETE FROM user_roles
+			  USING roles
+			  WHERE user_roles.role_id = roles.role_id AND user_roles.user_id = $1 AND roles.name = $2`
+
+	_, err := db.Exec(ctx, query, userID, roleName)
+	if err != nil {
+		log.Println("Ошибка снятия роли:", err)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SuggestSearchQuery исправляет опечатки в поисковом запросе: каждое слово
+// заменяется самым похожим словом из названий товаров, производителей и категорий
+// (материализованное представление search_words). Возвращает "", если исправлять нечего.
+func SuggestSearchQuery(db *pgxpool.Pool, ctx context.Context, searchQuery string) (string, error) {
+	query := `
+        SELECT string_agg(coalesce(best.word, typed.word), ' ' ORDER BY typed.ord)
+        FROM unnest(regexp_split_to_array(lower($1), '\s+')) WITH ORDINALITY AS typed(word, ord)
+        LEFT JOIN LATERAL (
+            SELECT search_words.word
+            FROM search_words
+            WHERE length(typed.word) >= 3 AND search_words.word % typed.word
+            ORDER BY similarity(search_words.word, typed.word) DESC, search_words.word
+            LIMIT 1
+        ) AS best ON true
+        WHERE typed.word <> ''`
+
+	var suggestion *string // NULL, если в запросе нет слов
+	err := db.QueryRow(ctx, query, searchQuery).Scan(&suggestion)
+	if err != nil {
+		log.Println("Ошибка подбора исправленного запроса:", err)
+		return "", err
+	}
+
+	if suggestion == nil || *suggestion == strings.ToLower(strings.Join(strings.Fields(searchQuery), " ")) {
+		return "", nil
+	}
+
+	return *suggestion, nil
+}
+
+// RefreshSearchWords пересчитывает словарь search_words. CONCURRENTLY не блокирует
+// чтение словаря на время пересчета (нужен уникальный индекс по word)
+func RefreshSearchWords(db *pgxpool.Pool, ctx context.Context) error {
+	_, err := db.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY search_words`)
+	if err != nil {
+		log.Println("Ошибка пересчета словаря поиска:", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetSearchSuggestions - до limit товаров, производителей и категорий, чье название
+// содержит запрос или похоже на него. Сначала названия, начинающиеся с запроса.
+func GetSearchSuggestions(db *pgxpool.Pool, ctx context.Context, searchQuery string, limit int) (*models.SearchSuggestions, error) {
+	query := `
+        (SELECT 'product', product_id, name FROM products
+         WHERE name ILIKE '%' || $1 || '%' OR $1 <% name
+         ORDER BY name ILIKE $1 || '%' DESC, word_similarity($1, name) DESC, name
+         LIMIT $2)
+        UNION ALL
+        (SELECT 'producer', producer_id, name FROM producers
+         WHERE name ILIKE '%' || $1 || '%' OR $1 <% name
+         ORDER BY name ILIKE $1 || '%' DESC, word_similarity($1, name) DESC, name
+         LIMIT $2)
+        UNION ALL
+        (SELECT 'category', category_id, name FROM categories
+         WHERE name ILIKE '%' || $1 || '%' OR $1 <% name
+         ORDER BY name ILIKE $1 || '%' DESC, word_similarity($1, name) DESC, name
+         LIMIT $2)`
+
+	rows, err := db.Query(ctx, query, searchQuery, limit)
+	if err != nil {
+		log.Println("Ошибка получения подсказок поиска:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Пустые списки, а не null: клиенту не нужно проверять каждое поле
+	suggestions := &models.SearchSuggestions{
+		Products:   []models.SearchSuggestion{},
+		Producers:  []models.SearchSuggestion{},
+		Categories: []models.SearchSuggestion{},
+	}
+
+	for rows.Next() {
+		var kind string
+		var suggestion models.SearchSuggestion
+		if err := rows.Scan(&kind, &suggestion.ID, &suggestion.Name); err != nil {
+			log.Println("Ошибка сканирования подсказки поиска:", err)
+			continue
+		}
+
+		switch kind {
+		case "product":
+			suggestions.Products = append(suggestions.Products, suggestion)
+		case "producer":
+			suggestions.Producers = append(suggestions.Producers, suggestion)
+		case "category":
+			suggestions.Categories = append(suggestions.Categories, suggestion)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrSessionNotFound возвращается, если сессия не существует, истекла или отозвана
+var ErrSessionNotFound = errors.New("session not found")
+
+func CreateSession(db *pgxpool.Pool, ctx context.Context, session *models.Session) error {
+	query := `INSERT INTO sessions (session_id, user_id, user_agent, ip_address, expires_at,
+			                      impersonator_id, impersonator_session_id)
+			  VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, ''))
+			  RETURNING created_at, last_seen_at`
+
+	err := db.QueryRow(ctx, query, session.ID, session.UserID, session.UserAgent, session.IPAddress,
+		session.ExpiresAt, session.ImpersonatorID, session.ImpersonatorSessionID).Scan(&session.CreatedAt, &session.LastSeenAt)
+
+	if err != nil {
+		log.Println("Ошибка создания сессии:", err)
+	}
+
+	return err
+}
+
+// GetActiveSession возвращает только живую сессию: не отозванную и не истекшую
+func GetActiveSession(db *pgxpool.Pool, ctx context.Context, sessionID string) (*models.Session, error) {
+	var session models.Session
+
+	query := `SELECT session_id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at,
+			         COALESCE(impersonator_id, 0), COALESCE(impersonator_session_id, '')
+			  FROM sessions
+			  WHERE session_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+
+	err := db.QueryRow(ctx, query, sessionID).Scan(&session.ID, &session.UserID, &session.UserAgent,
+		&session.IPAddress, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+		&session.ImpersonatorID, &session.ImpersonatorSessionID)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetUserSessions - устройства пользователя для профиля. Сессии сотрудников,
+// вошедших под пользователем, сюда не попадают.
+func GetUserSessions(db *pgxpool.Pool, ctx context.Context, userID int) ([]models.Session, error) {
+	query := `SELECT session_id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at
+			  FROM sessions
+			  WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW() AND impersonator_id IS NULL
+			  ORDER BY last_seen_at DESC`
+
+	rows, err := db.Query(ctx, query, userID)
+	if err != nil {
+		log.Println("Ошибка получения сессий пользователя:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent,
+			&session.IPAddress, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			log.Println("Ошибка сканирования сессии:", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// TouchSession обновляет время последней активности не чаще раза в минуту,
+// чтобы не писать в БД на каждый запрос
+func TouchSession(db *pgxpool.Pool, ctx context.Context, sessionID string) error {
+	query := `UPDATE sessions SET last_seen_at = NOW()
+			  WHERE session_id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute'`
+	_, err := db.Exec(ctx, query, sessionID)
+	return err
+}
+
+func RevokeSession(db *pgxpool.Pool, ctx context.Context, sessionID string) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE session_id = $1 AND revoked_at IS NULL`
+	_, err := db.Exec(ctx, query, sessionID)
+	return err
+}
+
+// RevokeUserSession отзывает сессию, только если она принадлежит пользователю
+func RevokeUserSession(db *pgxpool.Pool, ctx context.Context, userID int, sessionID string) error {
+	query := `UPDATE sessions SET revoked_at = NOW()
+			  WHERE session_id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	_, err := db.Exec(ctx, query, sessionID, userID)
+	return err
+}
+
+// RevokeUserSessions отзывает все живые сессии пользователя (выход со всех устройств)
+func RevokeUserSessions(db *pgxpool.Pool, ctx context.Context, userID int) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := db.Exec(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// GetUserTOTP возвращает зашифрованный секрет TOTP, признак включения 2FA
+// и номер последнего принятого интервала
+func GetUserTOTP(db *pgxpool.Pool, ctx context.Context, userID int) (string, bool, int64, error) {
+	var secret string
+	var enabled bool
+	var lastStep int64
+
+	query := `SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step FROM users WHERE id = $1`
+
+	err := db.QueryRow(ctx, query, userID).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		log.Println("Ошибка получения настроек 2FA:", err)
+		return "", false, 0, err
+	}
+
+	return secret, enabled, lastStep, nil
+}
+
+// SetPendingTOTPSecret сохраняет секрет, который еще не подтвержден кодом.
+// Секрет включенной 2FA не перезаписывается.
+func SetPendingTOTPSecret(db *pgxpool.Pool, ctx context.Context, userID int, encryptedSecret string) error {
+	query := `UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled = false`
+	_, err := db.Exec(ctx, query, userID, encryptedSecret)
+	if err != nil {
+		log.Println("Ошибка сохранения секрета 2FA:", err)
+	}
+	return err
+}
+
+// EnableTOTP включает 2FA и сохраняет хэши новых кодов восстановления
+func EnableTOTP(db *pgxpool.Pool, ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE users SET totp_enabled = true, totp_last_step = $2 WHERE id = $1`, userID, step)
+	if err != nil {
+		log.Println("Ошибка включения 2FA:", err)
+		return err
+	}
+
+	err = replaceRecoveryCodes(tx, ctx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+		return err
+	}
+
+	log.Printf("2FA включена для пользователя %d", userID)
+	return nil
+}
+
+// DisableTOTP выключает 2FA, удаляет секрет и коды восстановления
+func DisableTOTP(db *pgxpool.Pool, ctx context.Context, userID int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0
+						   WHERE id = $1`, userID)
+	if err != nil {
+		log.Println("Ошибка выключения 2FA:", err)
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Println("Ошибка удаления кодов восстановления:", err)
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+		return err
+	}
+
+	log.Printf("2FA выключена для пользователя %d", userID)
+	return nil
+}
+
+// UseTOTPStep запоминает принятый интервал. Возвращает false, если код
+// этого или более позднего интервала уже использовался (повтор кода).
+func UseTOTPStep(db *pgxpool.Pool, ctx context.Context, userID int, step int64) (bool, error) {
+	query := `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`
+
+	tag, err := db.Exec(ctx, query, userID, step)
+	if err != nil {
+		log.Println("Ошибка обновления интервала 2FA:", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode гасит одноразовый код восстановления
+func UseRecoveryCode(db *pgxpool.Pool, ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = NOW()
+			  WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	tag, err := db.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		log.Println("Ошибка использования кода восстановления:", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes возвращает число неиспользованных кодов восстановления
+func CountRecoveryCodes(db *pgxpool.Pool, ctx context.Context, userID int) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	err := db.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// ReplaceRecoveryCodes заменяет все коды восстановления пользователя новыми
+func ReplaceRecoveryCodes(db *pgxpool.Pool, ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = replaceRecoveryCodes(tx, ctx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println("Ошибка коммита транзакции:", err)
+	}
+	return err
+}
+
+func replaceRecoveryCodes(tx pgx.Tx, ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Println("Ошибка удаления кодов восстановления:", err)
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			log.Println("Ошибка сохранения кода восстановления:", err)
+			return err
+		}
+	}
+
+	return nil
+}
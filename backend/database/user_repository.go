@@ -2,16 +2,32 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"online_store/models"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrUserNotFound возвращается, если пользователя нет в таблице users
+var ErrUserNotFound = errors.New("user not found")
+
+// Ошибки проверки кодов подтверждения из письма
+var (
+	ErrCodeNotFound = errors.New("verification code not found")
+	ErrCodeExpired  = errors.New("verification code expired")
+	ErrCodeLocked   = errors.New("verification code locked")
+	ErrCodeCooldown = errors.New("verification code resend cooldown")
+	// Новых кодов в одном процессе больше нельзя, пока не истечет последний
+	ErrCodeResendLimit = errors.New("verification code resend limit reached")
+)
+
 func CreateUser(db *pgxpool.Pool, ctx context.Context, user *models.User) error {
-	query := `INSERT INTO users (email, password, name, status) VALUES ($1, $2, $3, $4 ) RETURNING id`
-	err := db.QueryRow(ctx, query, user.Email, user.Password, user.Name, user.Status).Scan(&user.ID)
+	query := `INSERT INTO users (email, password, name, account_state) VALUES ($1, $2, $3, $4 ) RETURNING id`
+	err := db.QueryRow(ctx, query, user.Email, user.Password, user.Name, user.AccountState).Scan(&user.ID)
 	if err != nil {
 		log.Println("Ошибка создания пользователя:", err)
 	}
@@ -23,13 +39,13 @@ func GetUser(db *pgxpool.Pool, ctx context.Context, id int) (*models.User, error
 
 	var user models.User
 
-	query := `SELECT id, email, password, name, status FROM users WHERE id=$1`
+	query := `SELECT id, email, password, name, account_state, totp_enabled FROM users WHERE id=$1`
 
-	err := db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Email, &user.Password, &user.Name, &user.Status)
+	err := db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Email, &user.Password, &user.Name, &user.AccountState, &user.TOTPEnabled)
 	if err != nil {
 		// Если пользователь не найден
 		if err.Error() == "no rows in result set" {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		// Другие ошибки базы данных
 		return nil, fmt.Errorf("database error: %w", err)
@@ -43,15 +59,15 @@ func GetUserByEmail(db *pgxpool.Pool, ctx context.Context, email string) (*model
 
 	var user models.User
 
-	query := `SELECT id, email, password, name, status FROM users WHERE email=$1`
+	query := `SELECT id, email, password, name, account_state, totp_enabled FROM users WHERE email=$1`
 	// Определяем запрос на основе идентификатора
 
 	// Выполняем запрос и проверяем ошибки
-	err := db.QueryRow(ctx, query, email).Scan(&user.ID, &user.Email, &user.Password, &user.Name, &user.Status)
+	err := db.QueryRow(ctx, query, email).Scan(&user.ID, &user.Email, &user.Password, &user.Name, &user.AccountState, &user.TOTPEnabled)
 	if err != nil {
 		// Если пользователь не найден
 		if err.Error() == "no rows in result set" {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		// Другие ошибки базы данных
 		return nil, fmt.Errorf("database error: %w", err)
@@ -62,38 +78,97 @@ func GetUserByEmail(db *pgxpool.Pool, ctx context.Context, email string) (*model
 }
 
 func UpdateUser(db *pgxpool.Pool, ctx context.Context, user *models.User) error {
-	query := `UPDATE users SET email=$1, password=$2, name=$3, status =$4 WHERE id=$5`
-	_, err := db.Exec(ctx, query, user.Email, user.Password, user.Name, user.Status, user.ID)
+	query := `UPDATE users SET email=$1, password=$2, name=$3, account_state=$4 WHERE id=$5`
+	_, err := db.Exec(ctx, query, user.Email, user.Password, user.Name, user.AccountState, user.ID)
 	return err
 }
 
-func DeleteUser(db *pgxpool.Pool, ctx context.Context, id int) error {
-	query := `DELETE FROM users WHERE id=$1`
-	_, err := db.Exec(ctx, query, id)
+// UpgradePasswordHash заменяет хеш пароля на более стойкий. Хеш меняется,
+// только если пароль не успели сменить параллельно.
+func UpgradePasswordHash(db *pgxpool.Pool, ctx context.Context, id int, oldHash, newHash string) error {
+	query := `UPDATE users SET password = $3 WHERE id = $1 AND password = $2`
+	_, err := db.Exec(ctx, query, id, oldHash, newHash)
+	if err != nil {
+		log.Println("Ошибка обновления хеша пароля:", err)
+	}
 	return err
 }
 
-func CreatePlaceholderUser(db *pgxpool.Pool, ctx context.Context, user *models.PlaceholderUser) error {
+// DeleteUser помечает аккаунт удаленным: персональные данные обезличиваются,
+// вход становится невозможен, а заказы остаются для отчетности
+func DeleteUser(db *pgxpool.Pool, ctx context.Context, id int) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Ошибка начала транзакции:", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	queryAnonymize := `UPDATE users
+					   SET account_state = 'deleted',
+						   email = 'deleted-' || id || '@deleted.invalid',
+						   name = 'Удаленный пользователь',
+						   password = '',
+						   totp_secret = NULL, totp_enabled = false
+					   WHERE id = $1`
+
+	queries := []string{
+		queryAnonymize,
+		`DELETE FROM carts WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_roles WHERE user_id = $1`,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		`DELETE FROM api_tokens WHERE user_id = $1`,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+	}
+
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query, id); err != nil {
+			log.Println("Ошибка удаления аккаунта:", err)
+			return err
+		}
+	}
 
-	query := `INSERT INTO pending_registrations (email, username, password, verification_code) 
-              VALUES ($1, $2, $3, $4)`
-	_, err := db.Exec(ctx, query, user.Email, user.Name, user.Password, user.VerificationCode)
+	return tx.Commit(ctx)
+}
+
+// CreatePlaceholderUser начинает регистрацию или заменяет незавершенную. Пока код
+// прежней регистрации не истек, замена считается повторной отправкой кода:
+// счетчик отправок не сбрасывается, и после maxResends новый код не выдается.
+func CreatePlaceholderUser(db *pgxpool.Pool, ctx context.Context, user *models.PlaceholderUser, maxResends int) error {
+
+	query := `INSERT INTO pending_registrations (email, username, password, verification_code, expires_at) 
+              VALUES ($1, $2, $3, $4, $5)
+              ON CONFLICT (email) DO UPDATE
+              SET username = EXCLUDED.username, password = EXCLUDED.password,
+                  verification_code = EXCLUDED.verification_code, expires_at = EXCLUDED.expires_at,
+                  attempts = 0, last_sent_at = NOW(),
+                  resends = CASE WHEN pending_registrations.expires_at < NOW() THEN 0
+                                 ELSE pending_registrations.resends + 1 END
+              WHERE pending_registrations.expires_at < NOW() OR pending_registrations.resends < $6`
+	tag, err := db.Exec(ctx, query, user.Email, user.Name, user.Password, user.VerificationCode, user.ExpiresAt, maxResends)
 
 	if err != nil {
 		log.Println("Ошибка создания пользователя:", err)
+		return err
 	}
 
-	return err
+	if tag.RowsAffected() == 0 {
+		return ErrCodeResendLimit
+	}
+
+	return nil
 }
 
 func GetPlaceholderUser(db *pgxpool.Pool, ctx context.Context, email string) (*models.PlaceholderUser, error) {
 	var user models.PlaceholderUser
 
-	query := `SELECT email, username, password, verification_code
+	query := `SELECT email, username, password, verification_code, attempts, expires_at, last_sent_at
 			 FROM pending_registrations WHERE email=$1`
 
 	// Выполняем запрос и проверяем ошибки
-	err := db.QueryRow(ctx, query, email).Scan(&user.Email, &user.Name, &user.Password, &user.VerificationCode)
+	err := db.QueryRow(ctx, query, email).Scan(&user.Email, &user.Name, &user.Password, &user.VerificationCode,
+		&user.Attempts, &user.ExpiresAt, &user.LastSentAt)
 
 	if err != nil {
 		// Если пользователь не найден
@@ -107,33 +182,99 @@ func GetPlaceholderUser(db *pgxpool.Pool, ctx context.Context, email string) (*m
 	return &user, nil
 }
 
+// UsePlaceholderCodeAttempt атомарно засчитывает попытку ввода кода регистрации
+// и возвращает данные для сравнения. Истекший или заблокированный код не проверяется.
+func UsePlaceholderCodeAttempt(db *pgxpool.Pool, ctx context.Context, email string, maxAttempts int) (*models.PlaceholderUser, error) {
+	var user models.PlaceholderUser
+
+	query := `UPDATE pending_registrations SET attempts = attempts + 1
+			  WHERE email = $1
+			  RETURNING email, username, password, verification_code, attempts, expires_at, last_sent_at`
+
+	err := db.QueryRow(ctx, query, email).Scan(&user.Email, &user.Name, &user.Password, &user.VerificationCode,
+		&user.Attempts, &user.ExpiresAt, &user.LastSentAt)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCodeNotFound
+		}
+		log.Println("Ошибка проверки кода регистрации:", err)
+		return nil, err
+	}
+
+	return checkCodeAttempt(&user, maxAttempts)
+}
+
+// RenewPlaceholderCode выдает новый код регистрации и сбрасывает счетчик попыток,
+// если с последней отправки прошло не меньше cooldown. Пока прежний код не истек,
+// новых кодов не больше maxResends: иначе перебор не ограничен числом попыток.
+func RenewPlaceholderCode(db *pgxpool.Pool, ctx context.Context, email string, code int, expiresAt time.Time,
+	cooldown time.Duration, maxResends int) error {
+
+	query := `UPDATE pending_registrations
+			  SET verification_code = $2, expires_at = $3, attempts = 0, last_sent_at = NOW(),
+				  resends = CASE WHEN expires_at < NOW() THEN 0 ELSE resends + 1 END
+			  WHERE email = $1 AND last_sent_at <= NOW() - make_interval(secs => $4)
+				AND (expires_at < NOW() OR resends < $5)`
+
+	tag, err := db.Exec(ctx, query, email, code, expiresAt, cooldown.Seconds(), maxResends)
+	if err != nil {
+		log.Println("Ошибка обновления кода регистрации:", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		user, err := GetPlaceholderUser(db, ctx, email)
+		if err != nil {
+			return ErrCodeNotFound
+		}
+		return renewRefusal(user, cooldown)
+	}
+
+	return nil
+}
+
 func DeletePlaceholderUser(db *pgxpool.Pool, ctx context.Context, email string) error {
 	query := `DELETE FROM pending_registrations WHERE email=$1`
 	_, err := db.Exec(ctx, query, email)
 	return err
 }
 
-func CreateUserInFPD(db *pgxpool.Pool, ctx context.Context, user *models.PlaceholderUser) error {
+// CreateUserInFPD начинает восстановление пароля. Повторный запрос до истечения
+// прежнего кода считается повторной отправкой (см. CreatePlaceholderUser).
+func CreateUserInFPD(db *pgxpool.Pool, ctx context.Context, user *models.PlaceholderUser, maxResends int) error {
 
-	query := `INSERT INTO forgot_password_date (email, verification_code) 
-              VALUES ($1, $2)`
-	_, err := db.Exec(ctx, query, user.Email, user.VerificationCode)
+	query := `INSERT INTO forgot_password_date (email, verification_code, expires_at) 
+              VALUES ($1, $2, $3)
+              ON CONFLICT (email) DO UPDATE
+              SET verification_code = EXCLUDED.verification_code, expires_at = EXCLUDED.expires_at,
+                  attempts = 0, last_sent_at = NOW(),
+                  resends = CASE WHEN forgot_password_date.expires_at < NOW() THEN 0
+                                 ELSE forgot_password_date.resends + 1 END
+              WHERE forgot_password_date.expires_at < NOW() OR forgot_password_date.resends < $4`
+	tag, err := db.Exec(ctx, query, user.Email, user.VerificationCode, user.ExpiresAt, maxResends)
 
 	if err != nil {
 		log.Println("Ошибка создания пользователя:", err)
+		return err
 	}
 
-	return err
+	if tag.RowsAffected() == 0 {
+		return ErrCodeResendLimit
+	}
+
+	return nil
 }
 
 func GetUserFromFPD(db *pgxpool.Pool, ctx context.Context, email string) (*models.PlaceholderUser, error) {
 	var user models.PlaceholderUser
 
-	query := `SELECT email, verification_code
+	query := `SELECT email, verification_code, attempts, expires_at, last_sent_at
 			 FROM forgot_password_date WHERE email=$1`
 
 	// Выполняем запрос и проверяем ошибки
-	err := db.QueryRow(ctx, query, email).Scan(&user.Email, &user.VerificationCode)
+	err := db.QueryRow(ctx, query, email).Scan(&user.Email, &user.VerificationCode,
+		&user.Attempts, &user.ExpiresAt, &user.LastSentAt)
 
 	if err != nil {
 		// Если пользователь не найден
@@ -147,6 +288,78 @@ func GetUserFromFPD(db *pgxpool.Pool, ctx context.Context, email string) (*model
 	return &user, nil
 }
 
+// UseFPDCodeAttempt атомарно засчитывает попытку ввода кода восстановления пароля
+func UseFPDCodeAttempt(db *pgxpool.Pool, ctx context.Context, email string, maxAttempts int) (*models.PlaceholderUser, error) {
+	var user models.PlaceholderUser
+
+	query := `UPDATE forgot_password_date SET attempts = attempts + 1
+			  WHERE email = $1
+			  RETURNING email, verification_code, attempts, expires_at, last_sent_at`
+
+	err := db.QueryRow(ctx, query, email).Scan(&user.Email, &user.VerificationCode,
+		&user.Attempts, &user.ExpiresAt, &user.LastSentAt)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCodeNotFound
+		}
+		log.Println("Ошибка проверки кода восстановления:", err)
+		return nil, err
+	}
+
+	return checkCodeAttempt(&user, maxAttempts)
+}
+
+// RenewFPDCode выдает новый код восстановления пароля с учетом cooldown
+// и лимита maxResends (см. RenewPlaceholderCode)
+func RenewFPDCode(db *pgxpool.Pool, ctx context.Context, email string, code int, expiresAt time.Time,
+	cooldown time.Duration, maxResends int) error {
+
+	query := `UPDATE forgot_password_date
+			  SET verification_code = $2, expires_at = $3, attempts = 0, last_sent_at = NOW(),
+				  resends = CASE WHEN expires_at < NOW() THEN 0 ELSE resends + 1 END
+			  WHERE email = $1 AND last_sent_at <= NOW() - make_interval(secs => $4)
+				AND (expires_at < NOW() OR resends < $5)`
+
+	tag, err := db.Exec(ctx, query, email, code, expiresAt, cooldown.Seconds(), maxResends)
+	if err != nil {
+		log.Println("Ошибка обновления кода восстановления:", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		user, err := GetUserFromFPD(db, ctx, email)
+		if err != nil {
+			return ErrCodeNotFound
+		}
+		return renewRefusal(user, cooldown)
+	}
+
+	return nil
+}
+
+// renewRefusal объясняет, почему новый код не выдан: не вышел cooldown
+// или исчерпан лимит отправок
+func renewRefusal(user *models.PlaceholderUser, cooldown time.Duration) error {
+	if time.Since(user.LastSentAt) < cooldown {
+		return ErrCodeCooldown
+	}
+	return ErrCodeResendLimit
+}
+
+// checkCodeAttempt проверяет срок действия кода и число уже сделанных попыток
+func checkCodeAttempt(user *models.PlaceholderUser, maxAttempts int) (*models.PlaceholderUser, error) {
+	if time.Now().After(user.ExpiresAt) {
+		return nil, ErrCodeExpired
+	}
+
+	if user.Attempts > maxAttempts {
+		return nil, ErrCodeLocked
+	}
+
+	return user, nil
+}
+
 func DeleteUserFromFPD(db *pgxpool.Pool, ctx context.Context, email string) error {
 	query := `DELETE FROM forgot_password_date WHERE email=$1`
 	_, err := db.Exec(ctx, query, email)
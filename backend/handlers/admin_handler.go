@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"online_store/database"
 	"online_store/models"
 	"online_store/utils"
@@ -24,53 +26,34 @@ func AdminPanelHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
 		ctx := r.Context()
-
-		// Проверяем права администратора
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil {
-			log.Println("Ошибка проверки статуса пользователя:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
-		if !isAdmin {
-			log.Println("Пользователь не является администратором")
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
+		principal := CurrentPrincipal(r)
 
 		// Подготавливаем данные для шаблона
 		data := struct {
-			Title          string
-			CurrentSection string
-			Action         string
-			Message        string
-			Success        bool
-			Products       []models.ProductForAdmin
-			Categories     []models.Category
-			Producers      []models.Producer
-			Product        models.ProductForAdmin
+			Title            string
+			CurrentSection   string
+			Action           string
+			Message          string
+			Success          bool
+			Products         []models.ProductForAdmin
+			Categories       []models.Category
+			Producers        []models.Producer
+			Product          models.ProductForAdmin
+			Lockouts         []models.LoginThrottle
+			StaffUsers       []models.StaffUser
+			Roles            []models.Role
+			APITokens        []models.APIToken
+			AuditEntries     []models.AuditEntry
+			AuditFilter      models.AuditFilter
+			AuditQuery       string // фильтры журнала для ссылки на выгрузку CSV
+			AuditActions     []string
+			AuditEntityTypes []string
+			Permissions      models.StringSet // права текущего сотрудника, для показа разделов
 		}{
 			Title:          "Админ-панель - GainWave",
-			CurrentSection: "products", // по умолчанию
+			CurrentSection: defaultAdminSection(principal),
+			Permissions:    principal.Permissions,
 		}
 
 		// Получаем параметры из URL
@@ -79,6 +62,12 @@ func AdminPanelHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 		data.Action = r.URL.Query().Get("action")
 
+		if permission, ok := adminSectionPermissions[data.CurrentSection]; ok && !principal.Can(permission) {
+			log.Printf("Пользователь %d не имеет права %s", principal.UserID, permission)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
 		// Загружаем данные в зависимости от раздела
 		switch data.CurrentSection {
 		case "products":
@@ -136,6 +125,64 @@ func AdminPanelHandler(db *pgxpool.Pool) http.HandlerFunc {
 			// Раздел бана пользователей - не требует дополнительных данных
 			// Просто показываем формы для бана/разбана
 
+		case "impersonate":
+			// Форма входа под покупателем - тоже без данных
+
+		case "lockouts":
+			// Счетчики неудачных входов и блокировки
+			lockouts, err := database.GetLoginThrottles(db, ctx)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data.Lockouts = lockouts
+
+		case "roles":
+			// Сотрудники с ролями и список ролей для назначения
+			staff, err := database.GetStaffUsers(db, ctx)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data.StaffUsers = staff
+
+			roles, err := database.GetRoles(db, ctx)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data.Roles = roles
+
+		case "tokens":
+			// Действующие персональные API токены всех пользователей
+			tokens, err := database.GetAllAPITokens(db, ctx)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data.APITokens = tokens
+
+		case "audit":
+			// Журнал действий: последние записи по фильтрам
+			data.AuditFilter = auditFilterFromRequest(r)
+			data.AuditFilter.Limit = auditPageLimit
+
+			entries, err := database.GetAuditEntries(db, ctx, &data.AuditFilter)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data.AuditEntries = entries
+			data.AuditActions = auditActions
+			data.AuditEntityTypes = auditEntityTypes
+			data.AuditQuery = url.Values{
+				"actor":       {data.AuditFilter.Actor},
+				"action_type": {data.AuditFilter.Action},
+				"entity_type": {data.AuditFilter.EntityType},
+				"entity_id":   {data.AuditFilter.EntityID},
+				"from":        {data.AuditFilter.From},
+				"to":          {data.AuditFilter.To},
+			}.Encode()
 		}
 
 		// Обработка сообщений
@@ -145,7 +192,7 @@ func AdminPanelHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		// Рендерим шаблон
-		err = utils.RenderTemplate(w, "admin.html", data)
+		err := utils.RenderTemplate(w, "admin.html", data)
 
 		if err != nil {
 			log.Println("Ошибка рендеринга шаблона", err)
@@ -155,6 +202,40 @@ func AdminPanelHandler(db *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// adminSectionPermissions - право, необходимое для каждого раздела админ-панели
+var adminSectionPermissions = map[string]string{
+	"products":    models.PermProductsWrite,
+	"ban":         models.PermUsersBan,
+	"impersonate": models.PermUsersImpersonate,
+	"lockouts":    models.PermSecurityManage,
+	"roles":       models.PermRolesManage,
+	"tokens":      models.PermSecurityManage,
+	"audit":       models.PermAuditRead,
+}
+
+// Действия и сущности, которые пишутся в журнал (для фильтров)
+var (
+	auditActions = []string{
+		"product.create", "product.update", "product.delete", "product.import",
+		"user.ban", "user.unban", "role.assign", "role.remove", "lockout.clear",
+		"api_token.revoke", "impersonation.start", "impersonation.stop",
+	}
+	auditEntityTypes = []string{"product", "user", "lockout", "api_token"}
+)
+
+// auditPageLimit - сколько последних записей журнала показывать на странице
+const auditPageLimit = 200
+
+// defaultAdminSection - первый раздел, доступный сотруднику
+func defaultAdminSection(principal *models.Principal) string {
+	for _, section := range []string{"products", "ban", "impersonate", "lockouts", "roles", "tokens", "audit"} {
+		if principal.Can(adminSectionPermissions[section]) {
+			return section
+		}
+	}
+	return ""
+}
+
 // AdminSaveProduct - сохранение товара (добавление и редактирование).
 func AdminSaveProductHandler(db *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -164,40 +245,8 @@ func AdminSaveProductHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
 		ctx := r.Context()
 
-		// Проверяем права администратора
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil {
-			log.Println("Ошибка проверки статуса пользователя:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-
-		}
-
-		if !isAdmin {
-			log.Println("Пользователь не является администратором")
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-
 		// Парсим форму
 		productID, _ := strconv.Atoi(r.FormValue("product_id"))
 		price, _ := strconv.ParseFloat(r.FormValue("price"), 32)
@@ -218,20 +267,35 @@ func AdminSaveProductHandler(db *pgxpool.Pool) http.HandlerFunc {
 
 		var message string
 		var success bool
+		var err error
 
 		// Сохраняем в базу
 		if productID == 0 {
 			// В HTML шаблоне при редактировании productID передается как 0, а при создании товара как 123
 			// Добавление нового товара
-			err = database.CreateProductForAdmin(db, ctx, product)
+			product.ProductID, err = database.CreateProductForAdmin(db, ctx, product)
 			message = "Товар успешно добавлен"
+			if err == nil {
+				recordAudit(db, r, "product.create", "product", strconv.Itoa(product.ProductID), nil, product)
+			}
 		} else {
 			// Редактирование существующего товара
-			err = database.UpdateProductForAdmin(db, ctx, product)
+			var before models.ProductForAdmin
+			before, err = database.GetProductForAdmin(db, ctx, productID)
+			if err == nil {
+				err = database.UpdateProductForAdmin(db, ctx, product)
+			}
 			message = "Товар успешно обновлен"
+			if err == nil {
+				recordAudit(db, r, "product.update", "product", strconv.Itoa(productID), before, product)
+			}
 		}
 
-		if err != nil {
+		if errors.Is(err, database.ErrProductHasVariants) {
+			log.Println(err)
+			message = "Ошибка сохранения: цена и количество товара с вариантами задаются в вариантах"
+			success = false
+		} else if err != nil {
 			log.Println(err)
 			message = "Ошибка сохранения: " + err.Error()
 			success = false
@@ -255,41 +319,17 @@ func AdminDeleteProductHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
 		ctx := r.Context()
 
-		// Проверяем права администратора
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil {
-			log.Println("Ошибка проверки статуса пользователя:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		productID, _ := strconv.Atoi(r.FormValue("product_id"))
 
-		if !isAdmin {
-			log.Println("Пользователь не является администратором")
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
+		before, err := database.GetProductForAdmin(db, ctx, productID)
+		if err == nil {
+			err = database.DeleteProductForAdmin(db, ctx, productID)
+		}
+		if err == nil {
+			recordAudit(db, r, "product.delete", "product", strconv.Itoa(productID), before, nil)
 		}
-
-		productID, _ := strconv.Atoi(r.FormValue("product_id"))
-		err = database.DeleteProductForAdmin(db, ctx, productID)
 
 		var message string
 		var success bool
@@ -316,51 +356,63 @@ func AdminBanUserHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
+		ctx := r.Context()
 
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		userID, _ := strconv.Atoi(r.FormValue("user_id"))
+
+		before, err := database.GetUser(db, ctx, userID)
+		if err == nil {
+			err = database.BanUser(db, ctx, userID)
+		}
+		if err == nil {
+			recordUserStateAudit(db, r, "user.ban", before)
 		}
 
-		user, err := validateToken(cookie.Value)
+		var message string
+		var success bool
 
 		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+			message = "Ошибка блокировки: " + err.Error()
+			success = false
+		} else {
+			message = "Пользователь заблокирован"
+			success = true
 		}
 
-		ctx := r.Context()
+		redirectURL := "/admin?section=users&message=" + message + "&success=" + strconv.FormatBool(success)
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
 
-		// Проверяем права администратора
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil {
-			log.Println("Ошибка проверки статуса пользователя:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// AdminUnbanUser - разблокировка пользователя
+func AdminUnbanUserHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
-
 		}
 
-		if !isAdmin {
-			log.Println("Пользователь не является администратором")
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
+		ctx := r.Context()
 
 		userID, _ := strconv.Atoi(r.FormValue("user_id"))
-		err = database.BanUser(db, r.Context(), userID)
+
+		before, err := database.GetUser(db, ctx, userID)
+		if err == nil {
+			err = database.UnbanUser(db, ctx, userID)
+		}
+		if err == nil {
+			recordUserStateAudit(db, r, "user.unban", before)
+		}
 
 		var message string
 		var success bool
 
 		if err != nil {
-			message = "Ошибка блокировки: " + err.Error()
+			message = "Ошибка разблокировки: " + err.Error()
 			success = false
 		} else {
-			message = "Пользователь заблокирован"
+			message = "Пользователь разблокирован"
 			success = true
 		}
 
@@ -369,8 +421,8 @@ func AdminBanUserHandler(db *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-// AdminUnbanUser - разблокировка пользователя
-func AdminUnbanUserHandler(db *pgxpool.Pool) http.HandlerFunc {
+// AdminClearLockoutHandler - снятие блокировки входа по email или IP
+func AdminClearLockoutHandler(db *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			log.Printf("Неподдерживаемый метод %s", r.Method)
@@ -378,59 +430,124 @@ func AdminUnbanUserHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
+		ctx := r.Context()
 
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		scope := r.FormValue("scope")
+		subject := r.FormValue("subject")
+
+		err := database.ClearLoginThrottle(db, ctx, scope, subject)
+		if err == nil {
+			recordAudit(db, r, "lockout.clear", "lockout", scope+":"+subject, nil, nil)
 		}
 
-		user, err := validateToken(cookie.Value)
+		var message string
+		var success bool
 
 		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			message = "Ошибка снятия блокировки: " + err.Error()
+			success = false
+		} else {
+			message = "Блокировка снята"
+			success = true
+		}
+
+		redirectURL := "/admin?section=lockouts&message=" + url.QueryEscape(message) + "&success=" + strconv.FormatBool(success)
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
+
+// AdminAssignRoleHandler - назначение роли пользователю
+func AdminAssignRoleHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
 		ctx := r.Context()
 
-		// Проверяем права администратора
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil {
-			log.Println("Ошибка проверки статуса пользователя:", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
+		userID, _ := strconv.Atoi(r.FormValue("user_id"))
+		role := r.FormValue("role")
+
+		var message string
+		var success bool
+
+		user, err := database.GetUser(db, ctx, userID)
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			message = "Пользователь не найден"
+		case err != nil:
+			message = "Ошибка назначения роли: " + err.Error()
+		case user.AccountState != models.AccountActive:
+			message = "Роль можно назначить только активному пользователю"
+		default:
+			err = database.AssignRole(db, ctx, userID, role)
+			if errors.Is(err, database.ErrRoleNotFound) {
+				message = "Роль не найдена"
+			} else if err != nil {
+				message = "Ошибка назначения роли: " + err.Error()
+			} else {
+				recordAudit(db, r, "role.assign", "user", strconv.Itoa(userID), nil, map[string]string{"role": role})
+				message = "Роль назначена"
+				success = true
+			}
 		}
 
-		if !isAdmin {
-			log.Println("Пользователь не является администратором")
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		redirectURL := "/admin?section=roles&message=" + url.QueryEscape(message) + "&success=" + strconv.FormatBool(success)
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
+
+// AdminRemoveRoleHandler - снятие роли с пользователя
+func AdminRemoveRoleHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		ctx := r.Context()
+		principal := CurrentPrincipal(r)
+
 		userID, _ := strconv.Atoi(r.FormValue("user_id"))
-		err = database.UnbanUser(db, ctx, userID)
+		role := r.FormValue("role")
 
 		var message string
 		var success bool
 
-		if err != nil {
-			message = "Ошибка разблокировки: " + err.Error()
-			success = false
+		// Нельзя случайно лишить себя доступа к управлению ролями
+		if userID == principal.UserID {
+			message = "Нельзя снять роль с самого себя"
+		} else if err := database.RemoveRole(db, ctx, userID, role); err != nil {
+			message = "Ошибка снятия роли: " + err.Error()
 		} else {
-			message = "Пользователь разблокирован"
+			recordAudit(db, r, "role.remove", "user", strconv.Itoa(userID), map[string]string{"role": role}, nil)
+			message = "Роль снята"
 			success = true
 		}
 
-		redirectURL := "/admin?section=users&message=" + message + "&success=" + strconv.FormatBool(success)
+		redirectURL := "/admin?section=roles&message=" + url.QueryEscape(message) + "&success=" + strconv.FormatBool(success)
 		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 	}
 }
 
-func GenerateReportHandler(db *pgxpool.Pool) http.HandlerFunc {
+// recordUserStateAudit пишет в журнал изменение состояния аккаунта
+func recordUserStateAudit(db *pgxpool.Pool, r *http.Request, action string, before *models.User) {
+	after, err := database.GetUser(db, r.Context(), before.ID)
+	if err != nil {
+		log.Println("Ошибка получения пользователя для журнала:", err)
+		return
+	}
+
+	recordAudit(db, r, action, "user", strconv.Itoa(before.ID),
+		map[string]string{"account_state": before.AccountState},
+		map[string]string{"account_state": after.AccountState})
+}
+
+// ExportAuditCSVHandler - выгрузка журнала действий в CSV с текущими фильтрами
+func ExportAuditCSVHandler(db *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			log.Printf("Неподдерживаемый метод %s", r.Method)
@@ -438,39 +555,43 @@ func GenerateReportHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
+		ctx := r.Context()
+		filter := auditFilterFromRequest(r)
 
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		filename := fmt.Sprintf("audit_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
 
-		user, err := validateToken(cookie.Value)
+		writer := csv.NewWriter(w)
+		defer writer.Flush()
 
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		headers := []string{"id", "created_at", "actor_id", "actor_email", "action", "entity_type", "entity_id", "before", "after", "ip_address"}
+		if err := writer.Write(headers); err != nil {
+			log.Println("Ошибка записи заголовков CSV:", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		ctx := r.Context()
-
-		// Проверяем права администратора
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil {
-			log.Println("Ошибка проверки статуса пользователя:", err)
+		if err := database.ExportAuditToCSV(db, ctx, &filter, writer); err != nil {
+			log.Println("Ошибка экспорта журнала:", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		if !isAdmin {
-			log.Println("Пользователь не является администратором")
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		log.Printf("Экспорт журнала действий завершен: %s", filename)
+	}
+}
+
+func GenerateReportHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		ctx := r.Context()
+
 		// Создаем Excel файл
 		f := excelize.NewFile()
 		defer f.Close()
@@ -580,24 +701,7 @@ func ExportProductsCSVHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-		if err != nil || cookie.Value == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
 		ctx := r.Context()
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil || !isAdmin {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
 
 		// Создаем CSV файл
 		filename := fmt.Sprintf("backup_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
@@ -616,7 +720,7 @@ func ExportProductsCSVHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		// Экспортируем данные
-		err = database.ExportProductsToCSV(db, ctx, writer)
+		err := database.ExportProductsToCSV(db, ctx, writer)
 		if err != nil {
 			log.Println("Ошибка экспорта данных:", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -635,24 +739,7 @@ func ImportProductsCSVHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-		if err != nil || cookie.Value == "" {
-			http.Redirect(w, r, "/admin?message=Unauthorized&success=false", http.StatusSeeOther)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-		if err != nil {
-			http.Redirect(w, r, "/admin?message=Unauthorized&success=false", http.StatusSeeOther)
-			return
-		}
-
 		ctx := r.Context()
-		isAdmin, err := database.CheckAdminStatus(db, ctx, user.ID)
-		if err != nil || !isAdmin {
-			http.Redirect(w, r, "/admin?message=Forbidden&success=false", http.StatusSeeOther)
-			return
-		}
 
 		// Обработка загружаемого файла
 		file, header, err := r.FormFile("csv_file")
@@ -669,13 +756,28 @@ func ImportProductsCSVHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		// Импортируем данные
-		imported, err := database.ImportProductsCSV(db, ctx, file)
+		imported, changes, err := database.ImportProductsCSV(db, ctx, file)
+		if errors.Is(err, database.ErrProductHasVariants) {
+			log.Println("Импорт отклонен:", err)
+			http.Redirect(w, r, "/admin?message=Импорт+отклонен:+цена+и+количество+товаров+с+вариантами+задаются+в+вариантах&success=false", http.StatusSeeOther)
+			return
+		}
 		if err != nil {
 			log.Println("Ошибка импорта данных:", err)
 			http.Redirect(w, r, "/admin?message=Ошибка+импорта+данных&success=false", http.StatusSeeOther)
 			return
 		}
 
+		// Отдельная запись с изменениями для каждого товара, как при ручном редактировании
+		for _, change := range changes {
+			var before interface{}
+			if change.Before != nil {
+				before = change.Before
+			}
+			recordAudit(db, r, "product.import", "product", strconv.Itoa(change.After.ProductID), before, change.After)
+		}
+		log.Printf("Импорт %s: изменено товаров %d", header.Filename, len(changes))
+
 		http.Redirect(w, r, fmt.Sprintf("/admin?message=Успешно+импортировано+%d+записей&success=true", imported), http.StatusSeeOther)
 	}
 }
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Префикс персональных токенов: по нему токен отличается от JWT
+	// и находится сканерами секретов в репозиториях
+	apiTokenPrefix = "gw_"
+	// Сколько действующих токенов может быть у пользователя
+	maxAPITokensPerUser = 20
+)
+
+// Сроки действия токена на выбор, в днях
+var apiTokenExpiryOptions = []int{30, 90, 365}
+
+// apiTokenFromRequest достает персональный токен из Authorization: Bearer gw_...
+func apiTokenFromRequest(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
+
+// authenticateAPIToken проверяет персональный токен и собирает Principal,
+// права которого - пересечение scopes токена и текущих прав пользователя.
+// При ошибке возвращает nil и HTTP статус ответа.
+func authenticateAPIToken(db *pgxpool.Pool, r *http.Request, rawToken string) (*models.Principal, int) {
+	ctx := r.Context()
+
+	token, err := database.GetActiveAPIToken(db, ctx, utils.HashToken(rawToken))
+	if err != nil {
+		if errors.Is(err, database.ErrAPITokenNotFound) {
+			log.Println("API токен не найден, истек или отозван")
+			return nil, http.StatusUnauthorized
+		}
+		return nil, http.StatusInternalServerError
+	}
+
+	user, err := database.GetUser(db, ctx, token.UserID)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	if user.AccountState != models.AccountActive {
+		log.Printf("API токен %d пользователя %d в состоянии %s", token.ID, user.ID, user.AccountState)
+		return nil, http.StatusForbidden
+	}
+
+	roles, permissions, err := database.GetUserAccess(db, ctx, user.ID)
+	if err != nil {
+		return nil, http.StatusInternalServerError
+	}
+
+	// Сотрудник выключил 2FA - его токены не работают, как и админка
+	if permissions.Contains(models.PermAdminAccess) && !user.TOTPEnabled {
+		log.Printf("API токен %d: у сотрудника %d нет 2FA", token.ID, user.ID)
+		return nil, http.StatusForbidden
+	}
+
+	granted := make(models.StringSet)
+	for _, scope := range token.Scopes {
+		if permissions.Contains(scope) {
+			granted[scope] = true
+		}
+	}
+
+	if err := database.TouchAPIToken(db, ctx, token.ID); err != nil {
+		log.Println("Ошибка обновления использования API токена:", err)
+	}
+
+	return &models.Principal{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Name:        user.Name,
+		Roles:       roles,
+		Permissions: granted,
+		APITokenID:  token.ID,
+
+		TwoFactorEnabled: user.TOTPEnabled,
+	}, 0
+}
+
+// apiTokenScopesFor - права пользователя, которые можно выдать токену
+func apiTokenScopesFor(principal *models.Principal) []string {
+	var scopes []string
+	for _, scope := range models.APITokenScopes {
+		if principal.Can(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// APITokensPageHandler показывает токены пользователя и форму выпуска
+func APITokensPageHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		if principal.IsStaff() && !principal.TwoFactorEnabled {
+			http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+			return
+		}
+
+		data := &models.APITokensPageData{}
+		if msg := r.URL.Query().Get("message"); msg != "" {
+			data.Message = msg
+			data.Success = r.URL.Query().Get("success") == "true"
+		}
+
+		renderAPITokensPage(db, w, r, data)
+	}
+}
+
+// CreateAPITokenHandler выпускает токен и показывает его один раз
+func CreateAPITokenHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		ctx := r.Context()
+
+		if principal.IsStaff() && !principal.TwoFactorEnabled {
+			http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		data := &models.APITokensPageData{}
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || utf8.RuneCountInString(name) > 100 {
+			data.Message = "Укажите название токена (до 100 символов)"
+			renderAPITokensPage(db, w, r, data)
+			return
+		}
+
+		allowed := apiTokenScopesFor(principal)
+		scopes := r.Form["scopes"]
+		if len(scopes) == 0 {
+			data.Message = "Выберите хотя бы одно право"
+			renderAPITokensPage(db, w, r, data)
+			return
+		}
+		for _, scope := range scopes {
+			if !slices.Contains(allowed, scope) {
+				data.Message = "Нельзя выдать токену право " + scope
+				renderAPITokensPage(db, w, r, data)
+				return
+			}
+		}
+
+		days, _ := strconv.Atoi(r.FormValue("expires_days"))
+		if !slices.Contains(apiTokenExpiryOptions, days) {
+			data.Message = "Выберите срок действия токена"
+			renderAPITokensPage(db, w, r, data)
+			return
+		}
+
+		count, err := database.CountActiveAPITokens(db, ctx, principal.UserID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if count >= maxAPITokensPerUser {
+			data.Message = "Слишком много действующих токенов, отзовите ненужные"
+			renderAPITokensPage(db, w, r, data)
+			return
+		}
+
+		secret, err := utils.NewRandomToken(32)
+		if err != nil {
+			log.Println("Ошибка генерации API токена:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		rawToken := apiTokenPrefix + secret
+
+		token := &models.APIToken{
+			UserID:    principal.UserID,
+			Name:      name,
+			Prefix:    rawToken[:len(apiTokenPrefix)+6],
+			Scopes:    scopes,
+			ExpiresAt: time.Now().Add(time.Duration(days) * 24 * time.Hour),
+		}
+
+		if err := database.CreateAPIToken(db, ctx, token, utils.HashToken(rawToken)); err != nil {
+			data.Message = "Ошибка создания токена"
+			renderAPITokensPage(db, w, r, data)
+			return
+		}
+
+		log.Printf("Пользователь %d выпустил API токен %d (%s)", principal.UserID, token.ID, strings.Join(scopes, ","))
+
+		data.NewToken = rawToken
+		data.Message = "Токен создан. Скопируйте его сейчас - больше он показан не будет"
+		data.Success = true
+		renderAPITokensPage(db, w, r, data)
+	}
+}
+
+// RevokeAPITokenHandler отзывает токен текущего пользователя
+func RevokeAPITokenHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		tokenID, _ := strconv.Atoi(r.FormValue("token_id"))
+
+		message := "Токен отозван"
+		success := true
+
+		err := database.RevokeUserAPIToken(db, r.Context(), tokenID, principal.UserID)
+		if err != nil {
+			message = "Токен не найден"
+			success = false
+		}
+
+		redirectURL := "/profile/tokens?message=" + url.QueryEscape(message) + "&success=" + strconv.FormatBool(success)
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
+
+// AdminRevokeAPITokenHandler - отзыв любого токена из админ-панели
+func AdminRevokeAPITokenHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		tokenID, _ := strconv.Atoi(r.FormValue("token_id"))
+
+		var message string
+		var success bool
+
+		token, err := database.RevokeAPIToken(db, r.Context(), tokenID)
+		if err != nil {
+			message = "Токен не найден или уже отозван"
+			success = false
+		} else {
+			recordAudit(db, r, "api_token.revoke", "api_token", strconv.Itoa(token.ID), map[string]interface{}{
+				"user":   token.UserEmail,
+				"name":   token.Name,
+				"prefix": token.Prefix,
+				"scopes": token.Scopes,
+			}, nil)
+			message = "Токен отозван"
+			success = true
+		}
+
+		redirectURL := "/admin?section=tokens&message=" + url.QueryEscape(message) + "&success=" + strconv.FormatBool(success)
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
+
+func renderAPITokensPage(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request, data *models.APITokensPageData) {
+	principal := CurrentPrincipal(r)
+
+	tokens, err := database.GetUserAPITokens(db, r.Context(), principal.UserID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data.Title = "API токены - GainWave"
+	data.Tokens = tokens
+	data.Scopes = apiTokenScopesFor(principal)
+	data.ExpiryOptions = apiTokenExpiryOptions
+
+	// Новый токен не должен оседать в кэше
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := utils.RenderTemplate(w, "profile_tokens.html", data); err != nil {
+		log.Println("Ошибка рендеринга profile_tokens.html", err)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// recordAudit пишет действие сотрудника в журнал. before и after - состояние
+// сущности до и после (любые значения, сериализуемые в JSON объект или nil);
+// в журнал попадают только поля, которые изменились.
+// Ошибка записи журнала не отменяет уже выполненное действие.
+func recordAudit(db *pgxpool.Pool, r *http.Request, action string, entityType string, entityID string, before, after interface{}) {
+	principal := CurrentPrincipal(r)
+	recordAuditAs(db, r, principal.UserID, principal.Email, action, entityType, entityID, before, after)
+}
+
+// recordAuditAs - то же для запросов, где сотрудника нет в контексте
+// (например, выход из режима входа под пользователем)
+func recordAuditAs(db *pgxpool.Pool, r *http.Request, actorID int, actorEmail string, action string, entityType string, entityID string, before, after interface{}) {
+	beforeJSON, afterJSON, err := auditDiff(before, after)
+	if err != nil {
+		log.Println("Ошибка подготовки записи журнала:", err)
+		return
+	}
+
+	entry := &models.AuditEntry{
+		ActorID:    &actorID,
+		ActorEmail: actorEmail,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		IPAddress:  utils.ClientIP(r),
+	}
+
+	if err := database.CreateAuditEntry(db, r.Context(), entry); err != nil {
+		log.Printf("Действие %s %s/%s не записано в журнал", action, entityType, entityID)
+	}
+}
+
+// auditDiff оставляет в before и after только отличающиеся поля
+func auditDiff(before, after interface{}) (string, string, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return "", "", err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Создание или удаление - сохраняем сущность целиком
+	if beforeFields == nil || afterFields == nil {
+		return marshalAuditFields(beforeFields), marshalAuditFields(afterFields), nil
+	}
+
+	changedBefore := map[string]interface{}{}
+	changedAfter := map[string]interface{}{}
+
+	for key, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[key]) {
+			changedBefore[key] = value
+		}
+	}
+	for key, value := range afterFields {
+		if !reflect.DeepEqual(value, beforeFields[key]) {
+			changedAfter[key] = value
+		}
+	}
+
+	return marshalAuditFields(changedBefore), marshalAuditFields(changedAfter), nil
+}
+
+func auditFields(value interface{}) (map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func marshalAuditFields(fields map[string]interface{}) string {
+	if fields == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// auditFilterFromRequest читает фильтры журнала из query-параметров
+func auditFilterFromRequest(r *http.Request) models.AuditFilter {
+	query := r.URL.Query()
+
+	return models.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action_type"),
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+	}
+}
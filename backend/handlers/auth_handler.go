@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -17,76 +20,373 @@ import (
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// Время жизни access JWT: украденный токен полезен недолго
+	accessTokenTTL = 15 * time.Minute
+	// Время жизни refresh токена и сессии; сдвигается при каждой ротации
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// Окно, в которое повторное предъявление только что погашенного refresh
+	// токена считается гонкой параллельных запросов, а не кражей
+	refreshTokenReuseGrace = 20 * time.Second
+	// Время жизни токена сброса пароля после подтверждения кода
+	resetTokenTTL = 15 * time.Minute
+
+	// Срок действия кода из письма
+	codeTTL = 10 * time.Minute
+	// Сколько раз можно ввести код, прежде чем он заблокируется
+	maxCodeAttempts = 5
+	// Минимальный интервал между отправками кода на один email
+	codeResendCooldown = 60 * time.Second
+	// Сколько новых кодов можно запросить, пока не истек предыдущий:
+	// сброс попыток при повторной отправке не дает перебирать код бесконечно
+	maxCodeResends = 3
+)
+
+// authClaims - данные, извлеченные из JWT
+type authClaims struct {
+	UserID    int
+	Email     string
+	SessionID string
+}
+
+// tokenPair - выданные клиенту access и refresh токены
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
 // Функция генерации JWT токена
-func generateToken(userID int, email string, status string) (string, error) {
+func generateToken(userID int, email string, sessionID string) (string, error) {
+	return utils.SignJWT(jwt.MapClaims{
+		"user_id": userID,
+		"email":   email,
+		"sid":     sessionID,
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
+// validateToken проверяет JWT токен и возвращает данные из него
 
-	if jwtSecret == "" {
-		jwtSecret = "temporary-dev-secret-change-in-production"
-		log.Println("ВНИМАНИЕ: Используется дефолтный JWT_SECRET!")
+func validateToken(tokenString string) (*authClaims, error) {
+	// Подпись проверяется ключом из заголовка kid
+	claims, err := utils.ParseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := claims["user_id"].(float64) // JWT числа становятся float64
+	email, _ := claims["email"].(string)
+	sessionID, _ := claims["sid"].(string)
+
+	// Токены без сессии (выпущенные до появления таблицы sessions) не принимаем
+	if sessionID == "" {
+		return nil, fmt.Errorf("в токене нет идентификатора сессии")
+	}
+
+	return &authClaims{
+		UserID:    int(userID),
+		Email:     email,
+		SessionID: sessionID,
+	}, nil
+}
+
+// issueTokens выпускает access JWT и новый refresh токен для существующей сессии
+func issueTokens(db *pgxpool.Pool, ctx context.Context, user *models.User, sessionID string) (*tokenPair, error) {
+	refreshToken, err := utils.NewRandomToken(32)
+	if err != nil {
+		log.Println("Ошибка генерации refresh токена:", err)
+		return nil, err
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
+	err = database.CreateRefreshToken(db, ctx, sessionID, utils.HashToken(refreshToken), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = userID
-	claims["email"] = email
-	claims["status"] = status
-	claims["exp"] = time.Now().Add(24 * time.Hour).Unix()
-	claims["iat"] = time.Now().Unix()
+	accessToken, err := generateToken(user.ID, user.Email, sessionID)
+	if err != nil {
+		log.Println("Ошибка генерации токена:", err)
+		return nil, err
+	}
 
-	return token.SignedString([]byte(jwtSecret))
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
 }
 
-// validateToken проверяет JWT токен и возвращает данные пользователя
+// rotateTokens меняет refresh токен на новую пару токенов.
+// Возвращает пользователя и сессию, к которым относятся токены.
+func rotateTokens(db *pgxpool.Pool, ctx context.Context, refreshToken string) (*models.User, string, *tokenPair, error) {
+	newRefreshToken, err := utils.NewRandomToken(32)
+	if err != nil {
+		log.Println("Ошибка генерации refresh токена:", err)
+		return nil, "", nil, err
+	}
 
-func validateToken(tokenString string) (*models.User, error) {
+	sessionID, userID, err := database.RotateRefreshToken(db, ctx,
+		utils.HashToken(refreshToken), utils.HashToken(newRefreshToken), refreshTokenTTL, refreshTokenReuseGrace)
+	if err != nil {
+		return nil, "", nil, err
+	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
+	user, err := database.GetUser(db, ctx, userID)
+	if err != nil {
+		return nil, "", nil, err
+	}
 
-	// Если переменная окружения не установлена, используем дефолтный
-	if jwtSecret == "" {
-		jwtSecret = "temporary-dev-secret-change-in-production"
-		log.Println("ВНИМАНИЕ: Используется дефолтный JWT_SECRET!")
+	if user.AccountState != models.AccountActive {
+		return nil, "", nil, database.ErrRefreshTokenInvalid
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Проверяем алгоритм подписи
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
+	accessToken, err := generateToken(user.ID, user.Email, sessionID)
+	if err != nil {
+		log.Println("Ошибка генерации токена:", err)
+		return nil, "", nil, err
+	}
+
+	return user, sessionID, &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// setAuthCookies сохраняет пару токенов в cookie браузера
+func setAuthCookies(w http.ResponseWriter, tokens *tokenPair) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "auth_token",
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   utils.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(accessTokenTTL.Seconds()),
 	})
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    tokens.RefreshToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   utils.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(refreshTokenTTL.Seconds()),
+	})
+}
+
+// authenticateRequest достает данные пользователя из Bearer заголовка или cookie.
+// Если access токен в cookie истек, прозрачно ротирует refresh токен
+// и выставляет новые cookie. API клиенты обновляют токены сами через /auth/refresh.
+func authenticateRequest(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request) (*authClaims, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		accessToken, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, fmt.Errorf("неподдерживаемая схема авторизации")
+		}
+		return validateToken(accessToken)
+	}
+
+	if cookie, err := r.Cookie("auth_token"); err == nil && cookie.Value != "" {
+		claims, err := validateToken(cookie.Value)
+		if err == nil {
+			return claims, nil
+		}
+		log.Println("Access токен недействителен, пробуем refresh:", err)
+	}
+
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil || cookie.Value == "" {
+		return nil, fmt.Errorf("нет токена авторизации")
+	}
+
+	user, sessionID, tokens, err := rotateTokens(db, r.Context(), cookie.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	// Проверяем валидность токена
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		user := &models.User{
-			ID:     int(claims["user_id"].(float64)), // JWT числа становятся float64
-			Email:  claims["email"].(string),
-			Status: claims["status"].(string),
+	setAuthCookies(w, tokens)
+
+	return &authClaims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		SessionID: sessionID,
+	}, nil
+}
+
+// startSession создает серверную сессию для пользователя и выставляет cookie
+// с access и refresh токенами, привязанными к этой сессии
+func startSession(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session, err := newSession(r, user.ID, refreshTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	err = database.CreateSession(db, ctx, session)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := issueTokens(db, ctx, user, session.ID)
+	if err != nil {
+		return err
+	}
+
+	setAuthCookies(w, tokens)
+
+	return nil
+}
+
+// inactiveAccountMessage - причина отказа во входе для неактивного аккаунта
+func inactiveAccountMessage(accountState string) string {
+	if accountState == models.AccountBanned {
+		return "Вы забанены по решению администратора, вы не можете зайти на сайт"
+	}
+	return "Аккаунт не найден или удален"
+}
+
+// newSession готовит запись сессии с устройством и IP из запроса
+func newSession(r *http.Request, userID int, ttl time.Duration) (*models.Session, error) {
+	sessionID, err := utils.NewRandomToken(32)
+	if err != nil {
+		log.Println("Ошибка генерации идентификатора сессии:", err)
+		return nil, err
+	}
+
+	// user_agent - VARCHAR(255) в символах: обрезаем по рунам, а не по байтам,
+	// и заменяем невалидный UTF-8, который Postgres не примет
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "\uFFFD")
+	if runes := []rune(userAgent); len(runes) > 255 {
+		userAgent = string(runes[:255])
+	}
+
+	return &models.Session{
+		ID:        sessionID,
+		UserID:    userID,
+		UserAgent: userAgent,
+		IPAddress: utils.ClientIP(r),
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// RefreshTokenHandler обменивает refresh токен на новую пару токенов.
+// Токен берется из поля refresh_token (JSON или форма) либо из cookie.
+func RefreshTokenHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var refreshToken string
+		fromCookie := false
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var body struct {
+				RefreshToken string `json:"refresh_token"`
+			}
+			err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body)
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			refreshToken = body.RefreshToken
+		} else {
+			refreshToken = r.FormValue("refresh_token")
+		}
+
+		if refreshToken == "" {
+			if cookie, err := r.Cookie("refresh_token"); err == nil {
+				refreshToken = cookie.Value
+				fromCookie = true
+			}
 		}
-		return user, nil
+
+		if refreshToken == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		_, _, tokens, err := rotateTokens(db, r.Context(), refreshToken)
+		if err != nil {
+			if errors.Is(err, database.ErrRefreshTokenInvalid) || errors.Is(err, database.ErrRefreshTokenReused) ||
+				errors.Is(err, database.ErrUserNotFound) {
+				log.Println("Отказ в обновлении токенов:", err)
+				if fromCookie {
+					utils.ClearCookie(w, r)
+				}
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			log.Println("Ошибка обновления токенов:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if fromCookie {
+			setAuthCookies(w, tokens)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(tokens); err != nil {
+			log.Println("Ошибка записи ответа:", err)
+		}
+	}
+}
+
+// JWKSHandler публикует публичные ключи проверки JWT (RS256/EdDSA), чтобы
+// другие сервисы могли проверять токены без общего секрета
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("Неподдерживаемый метод %s", r.Method)
+		return
 	}
 
-	return nil, fmt.Errorf("невалидный токен")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	if err := json.NewEncoder(w).Encode(utils.JWKS()); err != nil {
+		log.Println("Ошибка записи ответа:", err)
+	}
 }
 
+// HashPassword хеширует пароль алгоритмом из конфигурации (bcrypt или argon2id)
 func HashPassword(pwd string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost) // 10
-	return string(bytes), err
+	return utils.HashPassword(pwd)
 }
 
 func CheckPassword(pwd, hash_pwd string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash_pwd), []byte(pwd))
-	return err == nil
+	return utils.VerifyPassword(pwd, hash_pwd)
+}
+
+// upgradePasswordHash перехеширует пароль после успешного входа, если хеш
+// в БД создан другим алгоритмом или слабее текущих параметров.
+// Ошибка не мешает входу: попробуем при следующем.
+func upgradePasswordHash(db *pgxpool.Pool, ctx context.Context, user *models.User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+
+	newHash, err := HashPassword(password)
+	if err != nil {
+		log.Println("Ошибка перехеширования пароля:", err)
+		return
+	}
+
+	if err := database.UpgradePasswordHash(db, ctx, user.ID, user.Password, newHash); err != nil {
+		return
+	}
+	user.Password = newHash
 }
 
 // loginFormHandler обрабатывает отправку формы входа
@@ -138,9 +438,28 @@ func loginFormHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 		ctx := r.Context()
+
+		// Защита от перебора: проверяем задержку и блокировку до сверки пароля
+		throttleKeys := newLoginThrottleKeys(r, data.Email)
+		message, err := checkLoginThrottle(db, ctx, throttleKeys)
+		if err != nil {
+			data.Message = "Ошибка входа"
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
+		if message != "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			data.Message = message
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
+
 		// Ищем пользователя
 		user, err := database.GetUserByEmail(db, ctx, data.Email)
 		if err != nil {
+			if errors.Is(err, database.ErrUserNotFound) {
+				recordLoginFailure(db, ctx, throttleKeys, false)
+			}
 			data.Message = "Неверный email или пароль"
 			utils.RenderTemplate(w, "login.html", data)
 			return
@@ -148,45 +467,69 @@ func loginFormHandler(db *pgxpool.Pool) http.HandlerFunc {
 
 		// Проверяем пароль
 		if !CheckPassword(password, user.Password) {
+			recordLoginFailure(db, ctx, throttleKeys, true)
 			data.Message = "Неверный email или пароль"
 			utils.RenderTemplate(w, "login.html", data)
 			return
 		}
 
-		// Генерируем токен
-		token, err := generateToken(user.ID, user.Email, user.Status)
-		if err != nil {
-			data.Message = "Ошибка входа"
+		upgradePasswordHash(db, ctx, user, password)
+
+		// Забаненным и удаленным пользователям токен не выдаем
+		if user.AccountState != models.AccountActive {
+			data.Message = inactiveAccountMessage(user.AccountState)
 			utils.RenderTemplate(w, "login.html", data)
 			return
 		}
 
-		// Сохраняем токен в cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "auth_token",
-			Value:    token,
-			Path:     "/",
-			HttpOnly: true,
-			MaxAge:   24 * 60 * 60, // 24 часа
-		})
-
-		switch user.Status {
-		case "user":
-			http.Redirect(w, r, "/catalog", http.StatusSeeOther)
-		case "admin":
-			http.Redirect(w, r, "/admin", http.StatusSeeOther)
-		case "banned":
-			data.Message = "Вы забанены по решению администратора, вы не можете зайти на сайт"
-			utils.RenderTemplate(w, "login.html", data)
-			return
-		default:
-			// Обработка неизвестного статуса
-			data.Message = "Неизвестный статус пользователя"
+		err = finishLogin(db, w, r, user, throttleKeys)
+		if err != nil {
+			data.Message = "Ошибка входа"
 			utils.RenderTemplate(w, "login.html", data)
 			return
 		}
+	}
+}
 
+// finishLogin завершает вход после проверки первого фактора (пароль или ссылка
+// из письма): при включенной 2FA отправляет на второй шаг, иначе создает сессию.
+// При ошибке ответ еще не записан, и вызывающий показывает свою страницу.
+func finishLogin(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request, user *models.User, throttleKeys loginThrottleKeys) error {
+	// Включена 2FA: сессию выдаем только после второго шага.
+	// Счетчик неудач не сбрасываем, иначе, зная пароль, можно перебирать коды.
+	if user.TOTPEnabled {
+		if err := startMFAChallenge(w, user); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/login-2fa-page", http.StatusSeeOther)
+		return nil
 	}
+
+	clearLoginFailures(db, r.Context(), throttleKeys)
+
+	// Создаем сессию и выдаем токен
+	if err := startSession(db, w, r, user); err != nil {
+		return err
+	}
+
+	redirectAfterLogin(db, w, r, user)
+	return nil
+}
+
+// redirectAfterLogin отправляет пользователя на стартовую страницу по его правам.
+// Сотрудник без 2FA сначала попадает на ее настройку.
+func redirectAfterLogin(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request, user *models.User) {
+	_, permissions, err := database.GetUserAccess(db, r.Context(), user.ID)
+	if err != nil || !permissions.Contains(models.PermAdminAccess) {
+		http.Redirect(w, r, "/catalog", http.StatusSeeOther)
+		return
+	}
+
+	if !user.TOTPEnabled {
+		http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
 // registerFormHandler обрабатывает отправку формы регистрации
@@ -228,8 +571,8 @@ func registerFormHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		if len(password) < 6 {
-			data.Message = "Пароль должен содержать минимум 6 символов"
+		if err := utils.ValidatePassword(password, data.Email, data.Name); err != nil {
+			data.Message = err.Error()
 			utils.RenderTemplate(w, "login.html", data)
 			return
 		}
@@ -268,7 +611,15 @@ func registerFormHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		VerificationCode, err := SendCodeOnEmail(data.Email)
+		// Не даем заваливать почту письмами: новый код не чаще раза в cooldown
+		if pending, err := database.GetPlaceholderUser(db, r.Context(), data.Email); err == nil &&
+			time.Since(pending.LastSentAt) < codeResendCooldown {
+			data.Message = codeErrorMessage(database.ErrCodeCooldown)
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
+
+		VerificationCode, err := newVerificationCode()
 
 		if err != nil {
 			log.Println(err)
@@ -282,17 +633,24 @@ func registerFormHandler(db *pgxpool.Pool) http.HandlerFunc {
 			Password:         hashedPassword,
 			Name:             data.Name,
 			VerificationCode: VerificationCode,
+			ExpiresAt:        time.Now().Add(codeTTL),
 		}
 
 		ctx := r.Context()
-		_ = database.DeletePlaceholderUser(db, ctx, user.Email)
-		err = database.CreatePlaceholderUser(db, ctx, user)
+		err = database.CreatePlaceholderUser(db, ctx, user, maxCodeResends)
 
+		if errors.Is(err, database.ErrCodeResendLimit) {
+			data.Message = codeErrorMessage(err)
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		sendVerificationCode(data.Email, VerificationCode)
+
 		http.Redirect(w, r, "/verify-code-page?email="+data.Email, http.StatusSeeOther)
 
 	}
@@ -359,24 +717,25 @@ func verifyCodeHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		ctx := r.Context()
-		pendingUser, err := database.GetPlaceholderUser(db, ctx, email)
+		// Каждая проверка засчитывается как попытка, даже удачная
+		pendingUser, err := database.UsePlaceholderCodeAttempt(db, ctx, email, maxCodeAttempts)
 		if err != nil {
-			data.Message = "Ошибка получения данных пользователя"
+			data.Message = codeErrorMessage(err)
 			utils.RenderTemplate(w, "verify-code.html", data)
 			return
 		}
 
 		if enteredCodeInt != pendingUser.VerificationCode {
-			data.Message = "Неверный код"
+			data.Message = wrongCodeMessage(pendingUser.Attempts)
 			utils.RenderTemplate(w, "verify-code.html", data)
 			return
 		}
 
 		user := models.User{
-			Email:    pendingUser.Email,
-			Password: pendingUser.Password,
-			Name:     pendingUser.Name,
-			Status:   "user",
+			Email:        pendingUser.Email,
+			Password:     pendingUser.Password,
+			Name:         pendingUser.Name,
+			AccountState: models.AccountActive,
 		}
 
 		err = database.CreateUser(db, ctx, &user)
@@ -386,23 +745,14 @@ func verifyCodeHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		// Генерируем токен
-		token, err := generateToken(user.ID, user.Email, user.Status)
+		// Создаем сессию и выдаем токен
+		err = startSession(db, w, r, &user)
 		if err != nil {
 			data.Message = "Ошибка регистрации"
 			utils.RenderTemplate(w, "verify-code.html", data)
 			return
 		}
 
-		// Сохраняем токен в cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "auth_token",
-			Value:    token,
-			Path:     "/",
-			HttpOnly: true,
-			MaxAge:   24 * 60 * 60,
-		})
-
 		err = database.DeletePlaceholderUser(db, ctx, email)
 
 		if err != nil {
@@ -413,6 +763,43 @@ func verifyCodeHandler(db *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// ResendVerificationCodeHandler повторно отправляет код подтверждения регистрации
+func ResendVerificationCodeHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		email := r.FormValue("email") // из скрытого поля
+		if email == "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		data := models.TemplateData{Email: email}
+
+		code, err := newVerificationCode()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.RenewPlaceholderCode(db, r.Context(), email, code, time.Now().Add(codeTTL), codeResendCooldown, maxCodeResends)
+		if err != nil {
+			data.Message = codeErrorMessage(err)
+			utils.RenderTemplate(w, "verify-code.html", data)
+			return
+		}
+
+		sendVerificationCode(email, code)
+
+		data.Success = true
+		data.Message = "Новый код отправлен на почту"
+		utils.RenderTemplate(w, "verify-code.html", data)
+	}
+}
+
 func ServeForgotPasswordPage(db *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -462,7 +849,14 @@ func ForgotPasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		VerificationCode, err := SendCodeOnEmail(data.Email)
+		if pending, err := database.GetUserFromFPD(db, ctx, data.Email); err == nil &&
+			time.Since(pending.LastSentAt) < codeResendCooldown {
+			data.Message = codeErrorMessage(database.ErrCodeCooldown)
+			utils.RenderTemplate(w, "forgot_password.html", data)
+			return
+		}
+
+		VerificationCode, err := newVerificationCode()
 
 		if err != nil {
 			log.Println(err)
@@ -474,15 +868,22 @@ func ForgotPasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 		user := &models.PlaceholderUser{
 			Email:            data.Email,
 			VerificationCode: VerificationCode,
+			ExpiresAt:        time.Now().Add(codeTTL),
 		}
-		_ = database.DeleteUserFromFPD(db, ctx, data.Email)
-		err = database.CreateUserInFPD(db, ctx, user)
+		err = database.CreateUserInFPD(db, ctx, user, maxCodeResends)
 
+		if errors.Is(err, database.ErrCodeResendLimit) {
+			data.Message = codeErrorMessage(err)
+			utils.RenderTemplate(w, "forgot_password.html", data)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
+		sendVerificationCode(data.Email, VerificationCode)
+
 		// Перенаправляем на страницу ввода кода
 		http.Redirect(w, r, "/forgot-password-verify-page?email="+email, http.StatusSeeOther)
 	}
@@ -544,20 +945,84 @@ func ForgotPasswordVerifyHandler(db *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		ctx := r.Context()
-		pendingUser, err := database.GetUserFromFPD(db, ctx, email)
+		pendingUser, err := database.UseFPDCodeAttempt(db, ctx, email, maxCodeAttempts)
 		if err != nil {
-			data.Message = "Ошибка получения данных пользователя"
+			data.Message = codeErrorMessage(err)
 			utils.RenderTemplate(w, "forgot_password_verify.html", data)
 			return
 		}
 
 		if enteredCodeInt != pendingUser.VerificationCode {
-			data.Message = "Неверный код"
+			data.Message = wrongCodeMessage(pendingUser.Attempts)
+			utils.RenderTemplate(w, "forgot_password_verify.html", data)
+			return
+		}
+
+		user, err := database.GetUserByEmail(db, ctx, email)
+		if err != nil {
+			data.Message = "Ошибка получения данных пользователя"
 			utils.RenderTemplate(w, "forgot_password_verify.html", data)
 			return
 		}
 
-		http.Redirect(w, r, "/forgot-password-update-password-page?email="+email, http.StatusSeeOther)
+		// Код подтвержден - выдаем одноразовый токен, который предъявит форма нового пароля
+		resetToken, err := utils.NewRandomToken(32)
+		if err != nil {
+			log.Println("Ошибка генерации токена сброса пароля:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.CreatePasswordResetToken(db, ctx, user.ID, utils.HashToken(resetToken), time.Now().Add(resetTokenTTL))
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Код одноразовый
+		err = database.DeleteUserFromFPD(db, ctx, email)
+		if err != nil {
+			log.Println("Ошибка удаления данных из таблицы forgot_password_date")
+		}
+
+		http.Redirect(w, r, "/forgot-password-update-password-page?token="+resetToken, http.StatusSeeOther)
+	}
+}
+
+// ResendForgotPasswordCodeHandler повторно отправляет код восстановления пароля
+func ResendForgotPasswordCodeHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		email := r.FormValue("email") // скрытое поле
+		if email == "" {
+			http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+			return
+		}
+
+		data := models.TemplateData{Email: email}
+
+		code, err := newVerificationCode()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.RenewFPDCode(db, r.Context(), email, code, time.Now().Add(codeTTL), codeResendCooldown, maxCodeResends)
+		if err != nil {
+			data.Message = codeErrorMessage(err)
+			utils.RenderTemplate(w, "forgot_password_verify.html", data)
+			return
+		}
+
+		sendVerificationCode(email, code)
+
+		data.Success = true
+		data.Message = "Новый код отправлен на почту"
+		utils.RenderTemplate(w, "forgot_password_verify.html", data)
 	}
 }
 
@@ -569,13 +1034,26 @@ func ServeForgotPasswordUpdatePasswordPage(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		email := r.URL.Query().Get("email")
-		if email == "" {
+		token := r.URL.Query().Get("token")
+		if token == "" {
 			http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
 			return
 		}
 
-		data := models.TemplateData{Email: email}
+		_, err := database.GetPasswordResetTokenUser(db, r.Context(), utils.HashToken(token))
+		if err != nil {
+			if !errors.Is(err, database.ErrResetTokenInvalid) {
+				log.Println("Ошибка проверки токена сброса пароля:", err)
+			}
+			data := models.TemplateData{Message: "Ссылка для сброса пароля устарела, запросите код еще раз"}
+			utils.RenderTemplate(w, "forgot_password.html", data)
+			return
+		}
+
+		// Токен в URL не должен утечь через Referer
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		data := models.TemplateData{Token: token}
 		utils.RenderTemplate(w, "forgot_password_update_password.html", data)
 	}
 }
@@ -595,22 +1073,21 @@ func ForgotPasswordUpdatePasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		email := r.FormValue("email") // скрытое поле
+		token := r.FormValue("token") // скрытое поле
 		ConfirmPassword := r.FormValue("confirm_password")
 		NewPassword := r.FormValue("new_password")
 
 		data := models.TemplateData{
-			Email: email,
+			Token: token,
 		}
 
-		if NewPassword == "" || ConfirmPassword == "" {
-			data.Message = "Поля должны быть заполнены"
-			utils.RenderTemplate(w, "forgot_password_update_password.html", data)
+		if token == "" {
+			http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
 			return
 		}
 
-		if len(NewPassword) < 6 || len(ConfirmPassword) < 6 {
-			data.Message = "Пароль должен содержать минимум 6 символов"
+		if NewPassword == "" || ConfirmPassword == "" {
+			data.Message = "Поля должны быть заполнены"
 			utils.RenderTemplate(w, "forgot_password_update_password.html", data)
 			return
 		}
@@ -623,31 +1100,56 @@ func ForgotPasswordUpdatePasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 
 		ctx := r.Context()
 
-		NewPasswordHash, err := HashPassword(NewPassword)
+		// Email и имя нужны политике паролей; заодно отсекаем устаревший токен
+		userID, err := database.GetPasswordResetTokenUser(db, ctx, utils.HashToken(token))
+		if err != nil {
+			if !errors.Is(err, database.ErrResetTokenInvalid) {
+				log.Println("Ошибка проверки токена сброса пароля:", err)
+			}
+			data := models.TemplateData{Message: "Ссылка для сброса пароля устарела, запросите код еще раз"}
+			utils.RenderTemplate(w, "forgot_password.html", data)
+			return
+		}
 
+		user, err := database.GetUser(db, ctx, userID)
 		if err != nil {
-			log.Println("Ошибка хэширования пароля")
-			data.Message = "Неверный текущий пароль!"
-			utils.RenderTemplate(w, "change_password.html", data)
+			log.Println("Ошибка получения пользователя:", err)
+			data.Message = "Серверная ошибка, попробуйте еще раз"
+			utils.RenderTemplate(w, "forgot_password_update_password.html", data)
+			return
+		}
+
+		if err := utils.ValidatePassword(NewPassword, user.Email, user.Name); err != nil {
+			data.Message = err.Error()
+			utils.RenderTemplate(w, "forgot_password_update_password.html", data)
 			return
 		}
-		quary := "UPDATE users SET password = $1 WHERE email = $2"
 
-		_, err = db.Exec(ctx, quary, NewPasswordHash, email)
+		NewPasswordHash, err := HashPassword(NewPassword)
 
 		if err != nil {
+			log.Println("Ошибка хэширования пароля")
 			data.Message = "Серверная ошибка, попробуйте еще раз"
-			utils.RenderTemplate(w, "change_password.html", data)
+			utils.RenderTemplate(w, "forgot_password_update_password.html", data)
 			return
 		}
 
-		err = database.DeleteUserFromFPD(db, ctx, email)
+		// Пароль меняется только владельцу токена; токен и все остальные
+		// токены сброса пользователя гасятся, сессии отзываются
+		_, err = database.ResetPasswordByToken(db, ctx, utils.HashToken(token), NewPasswordHash)
 
 		if err != nil {
-			log.Println("Ошибка удаления данных из таблицы pending_registrations")
+			if errors.Is(err, database.ErrResetTokenInvalid) {
+				data := models.TemplateData{Message: "Ссылка для сброса пароля устарела, запросите код еще раз"}
+				utils.RenderTemplate(w, "forgot_password.html", data)
+				return
+			}
+			data.Message = "Серверная ошибка, попробуйте еще раз"
+			utils.RenderTemplate(w, "forgot_password_update_password.html", data)
+			return
 		}
 
-		http.Redirect(w, r, "/catalog", http.StatusSeeOther)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
@@ -682,31 +1184,63 @@ func SendReceiptOnEmailAsync(userEmail string, orderID int) {
 
 }
 
-func SendCodeOnEmail(userEmail string) (int, error) {
-
+// newVerificationCode генерирует 5-значный код подтверждения
+func newVerificationCode() (int, error) {
 	VerificationСode, err := CreateRandomNum()
 	if err != nil {
 		return 0, err
 	}
+
+	VerificationСodeInt, err := strconv.Atoi(VerificationСode)
+
+	if err != nil {
+		log.Println("Ошибка конвертации atoi:", err)
+		return 0, err
+	}
+
+	return VerificationСodeInt, nil
+}
+
+// sendVerificationCode асинхронно отправляет код подтверждения на почту
+func sendVerificationCode(userEmail string, code int) {
 	message := []byte("Subject: Код подтверждения\r\n" +
 		"\r\n" + // Пустая строка между заголовками и телом
 		"Здравствуйте!\r\n" +
-		"Ваш код подтверждения: " + VerificationСode + "\r\n" +
+		"Ваш код подтверждения: " + fmt.Sprintf("%05d", code) + "\r\n" +
+		"Код действует " + strconv.Itoa(int(codeTTL.Minutes())) + " минут.\r\n" +
 		"Используйте его для входа в аккаунт.\r\n" +
 		"\r\n" +
 		"Если это были не вы, проигнорируйте это сообщение.\r\n" +
 		"С уважением, Gain Wave.\r\n")
 
 	go SendCodeOnEmailAsync(userEmail, message)
+}
 
-	VerificationСodeInt, err := strconv.Atoi(VerificationСode)
-
-	if err != nil {
-		log.Println("Ошибка конвертации atoi:", err)
-		return 0, err
+// codeErrorMessage переводит ошибку проверки кода в сообщение для пользователя
+func codeErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, database.ErrCodeExpired):
+		return "Срок действия кода истек, запросите новый код"
+	case errors.Is(err, database.ErrCodeLocked):
+		return "Превышено число попыток ввода, запросите новый код"
+	case errors.Is(err, database.ErrCodeNotFound):
+		return "Код не найден, запросите новый код"
+	case errors.Is(err, database.ErrCodeCooldown):
+		return "Повторно запросить код можно не чаще раза в минуту"
+	case errors.Is(err, database.ErrCodeResendLimit):
+		return "Превышено число запросов кода, попробуйте через 10 минут"
+	default:
+		return "Ошибка получения данных пользователя"
 	}
+}
 
-	return VerificationСodeInt, nil
+// wrongCodeMessage сообщает, сколько попыток осталось после неверного кода
+func wrongCodeMessage(attempts int) string {
+	left := maxCodeAttempts - attempts
+	if left <= 0 {
+		return "Неверный код, попытки исчерпаны, запросите новый код"
+	}
+	return fmt.Sprintf("Неверный код, осталось попыток: %d", left)
 }
 
 func SendCodeOnEmailAsync(email string, message []byte) {
@@ -743,13 +1277,45 @@ func CreateRandomNum() (string, error) {
 	return fmt.Sprintf("%05d", randomNum), nil
 }
 
-// Обработчик выхода
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		utils.ClearCookie(w, r)
+// Обработчик выхода: отзывает текущую сессию на сервере и очищает cookie
+func logoutHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			sessionID := ""
+
+			if cookie, err := r.Cookie("auth_token"); err == nil && cookie.Value != "" {
+				if claims, err := validateToken(cookie.Value); err == nil {
+					sessionID = claims.SessionID
+				}
+			}
+
+			// Access токен мог истечь - находим сессию по refresh токену
+			if sessionID == "" {
+				if cookie, err := r.Cookie("refresh_token"); err == nil && cookie.Value != "" {
+					sessionID, _ = database.GetRefreshTokenSession(db, r.Context(), utils.HashToken(cookie.Value))
+				}
+			}
+
+			// Выход из режима входа под пользователем возвращает сотрудника в его сессию
+			if sessionID != "" {
+				session, err := database.GetActiveSession(db, r.Context(), sessionID)
+				if err == nil && session.ImpersonatorID != 0 {
+					http.Redirect(w, r, endImpersonation(db, w, r, session), http.StatusSeeOther)
+					return
+				}
+			}
+
+			if sessionID != "" {
+				err := database.RevokeSession(db, r.Context(), sessionID)
+				if err != nil {
+					log.Println("Ошибка отзыва сессии:", err)
+				}
+			}
+			utils.ClearCookie(w, r)
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
-
-	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 // Метод Handler
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -20,27 +22,12 @@ func CartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		user := CurrentPrincipal(r)
 		ctx := r.Context()
 
-		cart_id, err := database.GetCartIDUser(db, ctx, user.ID)
+		cart_id, err := database.GetCartIDUser(db, ctx, user.UserID)
 		if err != nil {
-			cart_id, err = database.CreateCartForUser(db, ctx, user.ID)
+			cart_id, err = database.CreateCartForUser(db, ctx, user.UserID)
 			if err != nil {
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				log.Println("Ошибка создания корзины пользователя", err)
@@ -93,24 +80,9 @@ func AddProductToCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		user := CurrentPrincipal(r)
 
-		err = r.ParseForm()
+		err := r.ParseForm()
 		if err != nil {
 			log.Println("Ошибка парсинга формы", err)
 			http.Error(w, "Bad request", http.StatusBadRequest)
@@ -133,11 +105,30 @@ func AddProductToCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			http.Error(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
+		// variant_id обязателен для товаров с вариантами
+		variantID, err := optionalFormInt(r, "variant_id")
+		if err != nil {
+			log.Println("Ошибка конвертации variant_id atoi", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
 		ctx := r.Context()
-		cartID, err := database.GetCartIDUser(db, ctx, user.ID)
+		err = database.CheckCartVariant(db, ctx, productID, variantID)
+		if errors.Is(err, database.ErrVariantRequired) || errors.Is(err, database.ErrVariantNotFound) {
+			log.Printf("Неверный вариант %d товара %d: %v", variantID, productID, err)
+			http.Error(w, "Invalid product variant", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		cartID, err := database.GetCartIDUser(db, ctx, user.UserID)
 		if err != nil {
 			// Если корзины нет - создаем
-			cartID, err = database.CreateCartForUser(db, ctx, user.ID)
+			cartID, err = database.CreateCartForUser(db, ctx, user.UserID)
 			if err != nil {
 				log.Println("Ошибка создания корзины", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -145,7 +136,7 @@ func AddProductToCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			}
 		}
 
-		err = database.AddProductToCart(db, ctx, cartID, productID, quantity)
+		err = database.AddProductToCart(db, ctx, cartID, productID, variantID, quantity)
 
 		if err != nil {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -153,6 +144,11 @@ func AddProductToCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		} else {
 			log.Println("Товар добавлен в корзину: ")
+			// Со страницы товара возвращаем на нее же
+			if r.FormValue("from") == "product" {
+				http.Redirect(w, r, "/product/"+strconv.Itoa(productID), http.StatusSeeOther)
+				return
+			}
 			http.Redirect(w, r, "/catalog", http.StatusSeeOther)
 		}
 
@@ -168,24 +164,9 @@ func DeleteProductFromCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
+		user := CurrentPrincipal(r)
 
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		err = r.ParseForm()
+		err := r.ParseForm()
 		if err != nil {
 			log.Print("Ошибка парсинга формы", err)
 			http.Error(w, "Bad request", http.StatusBadRequest)
@@ -201,14 +182,21 @@ func DeleteProductFromCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		variantID, err := optionalFormInt(r, "variant_id")
+		if err != nil {
+			log.Println("Ошибка конвертации variant_id atoi", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
 		ctx := r.Context()
-		cartID, err := database.GetCartIDUser(db, ctx, user.ID)
+		cartID, err := database.GetCartIDUser(db, ctx, user.UserID)
 		if err != nil {
 			log.Print("Ошибка получения ID пользователя", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		err = database.DeleteProductFromCart(db, ctx, cartID, product_id)
+		err = database.DeleteProductFromCart(db, ctx, cartID, product_id, variantID)
 		if err != nil {
 			log.Print("Ошибка удаления элемента корзины", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -218,3 +206,16 @@ func DeleteProductFromCartHandler(db *pgxpool.Pool) http.HandlerFunc {
 		log.Println("Товар удален из корзины")
 	}
 }
+
+// optionalFormInt - необязательное целое поле формы; пустое значение - 0
+func optionalFormInt(r *http.Request, name string) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return number, nil
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Коды ошибок JSON API: клиенты ветвятся по ним, а не по тексту
+const (
+	apiErrInvalidParameter = "invalid_parameter"
+	apiErrNotFound         = "not_found"
+	apiErrMethodNotAllowed = "method_not_allowed"
+	apiErrInternal         = "internal_error"
+)
+
+// apiResponse - общий конверт ответа API: либо data (и meta для списков), либо error
+type apiResponse struct {
+	Data  interface{} `json:"data,omitempty"`
+	Meta  interface{} `json:"meta,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// apiPageMeta - страница списка и общее количество записей
+type apiPageMeta struct {
+	Page       int `json:"page"`
+	PageSize   int `json:"page_size"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+
+	// Поиск с опечаткой: товары найдены по сходству, а не точному совпадению
+	Similar    bool   `json:"similar,omitempty"`
+	DidYouMean string `json:"did_you_mean,omitempty"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response apiResponse) {
+	// Каталог публичный: партнерские сайты читают его прямо из браузера
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Println("Ошибка записи ответа API:", err)
+	}
+}
+
+func writeAPIData(w http.ResponseWriter, data interface{}, meta interface{}) {
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeAPIResponse(w, http.StatusOK, apiResponse{Data: data, Meta: meta})
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string, message string) {
+	writeAPIResponse(w, status, apiResponse{Error: &apiError{Code: code, Message: message}})
+}
+
+// apiGetOnly отвечает 405 в формате API на всё, кроме GET
+func apiGetOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+
+	log.Printf("Неподдерживаемый метод %s для %s", r.Method, r.URL.Path)
+	w.Header().Set("Allow", http.MethodGet)
+	writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethodNotAllowed, "Method not allowed")
+	return false
+}
+
+func toAPIProduct(product *models.ProductForFilter) models.APIProduct {
+	apiProduct := models.APIProduct{
+		ID:       product.ProductID,
+		Slug:     product.Slug,
+		Name:     product.Name,
+		Price:    product.Price,
+		StockQty: product.StockQuantity,
+		InStock:  product.StockQuantity > 0,
+		ImageURL: product.Image,
+		Category: models.APICategory{ID: product.CategoryID, Name: product.CategoryName},
+		Producer: models.APIProducer{ID: product.ProducerID, Name: product.ProducerName},
+
+		HasVariants: product.VariantCount > 0,
+	}
+
+	if product.NameHighlight != "" {
+		apiProduct.NameHTML = string(utils.HighlightHTML(product.NameHighlight))
+	}
+	if product.Snippet != "" {
+		apiProduct.SnippetHTML = string(utils.HighlightHTML(product.Snippet))
+	}
+
+	return apiProduct
+}
+
+// APIProductsHandler - GET /api/v1/products с теми же фильтрами, что и /catalog
+func APIProductsHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiGetOnly(w, r) {
+			return
+		}
+
+		filterParams, err := filterParamsFromQuery(r.URL.Query())
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidParameter, err.Error())
+			return
+		}
+
+		found, err := searchCatalog(db, r.Context(), filterParams)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal server error")
+			return
+		}
+
+		result := make([]models.APIProduct, 0, len(found.Products))
+		for i := range found.Products {
+			result = append(result, toAPIProduct(&found.Products[i]))
+		}
+
+		writeAPIData(w, result, apiPageMeta{
+			Page:       filterParams.Page,
+			PageSize:   filterParams.PageSize,
+			Total:      found.Total,
+			TotalPages: (found.Total + filterParams.PageSize - 1) / filterParams.PageSize,
+			Similar:    filterParams.Fuzzy && found.Total > 0,
+			DidYouMean: found.DidYouMean,
+		})
+	}
+}
+
+// APIProductHandler - GET /api/v1/products/{id}
+func APIProductHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiGetOnly(w, r) {
+			return
+		}
+
+		productID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil || productID <= 0 {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidParameter, "invalid product ID")
+			return
+		}
+
+		product, err := database.GetCatalogProduct(db, r.Context(), productID)
+		if err != nil {
+			if errors.Is(err, database.ErrProductNotFound) {
+				writeAPIError(w, http.StatusNotFound, apiErrNotFound, "product not found")
+				return
+			}
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal server error")
+			return
+		}
+
+		apiProduct := toAPIProduct(product)
+		if apiProduct.HasVariants {
+			variants, err := database.GetProductVariants(db, r.Context(), productID)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal server error")
+				return
+			}
+			for _, variant := range variants {
+				apiProduct.Variants = append(apiProduct.Variants, models.APIVariant{
+					ID:       variant.VariantID,
+					SKU:      variant.SKU,
+					Flavor:   variant.Flavor,
+					Size:     variant.Size,
+					Price:    variant.Price,
+					StockQty: variant.StockQuantity,
+					InStock:  variant.StockQuantity > 0,
+					ImageURL: variant.Image,
+				})
+			}
+		}
+
+		writeAPIData(w, apiProduct, nil)
+	}
+}
+
+// APICategoriesHandler - GET /api/v1/categories
+func APICategoriesHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiGetOnly(w, r) {
+			return
+		}
+
+		categories, err := database.GetCategories(db, r.Context())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal server error")
+			return
+		}
+
+		result := make([]models.APICategory, 0, len(categories))
+		for _, category := range categories {
+			result = append(result, models.APICategory{ID: category.ID, Name: category.Name})
+		}
+
+		writeAPIData(w, result, nil)
+	}
+}
+
+// APIProducersHandler - GET /api/v1/producers
+func APIProducersHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiGetOnly(w, r) {
+			return
+		}
+
+		producers, err := database.GetProducers(db, r.Context())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal server error")
+			return
+		}
+
+		result := make([]models.APIProducer, 0, len(producers))
+		for _, producer := range producers {
+			result = append(result, models.APIProducer{ID: producer.ID, Name: producer.Name, Country: producer.Country})
+		}
+
+		writeAPIData(w, result, nil)
+	}
+}
+
+// APINotFoundHandler - неизвестные пути под /api/v1/ отвечают в формате API, а не страницей входа
+func APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, apiErrNotFound, "unknown API endpoint")
+}
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"online_store/database"
 	"online_store/models"
 	"online_store/utils"
 	"strconv"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -20,22 +25,6 @@ func serveCatalog(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		_, err = validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
 		ctx := r.Context()
 
 		// Получаем категории и производителей из БД
@@ -53,60 +42,30 @@ func serveCatalog(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		// Парсим параметры фильтрации
-		searchQuery := r.URL.Query().Get("search")
-		minPriceStr := r.URL.Query().Get("price_min")
-		maxPriceStr := r.URL.Query().Get("price_max")
-		categoryParams := r.URL.Query()["category"]
-		producerParams := r.URL.Query()["producer"]
-		sortBy := r.URL.Query().Get("sortBy")
+		// Парсим параметры фильтрации; некорректные значения в каталоге просто пропускаем
+		query := r.URL.Query()
+		searchQuery := query.Get("search")
+		minPriceStr := query.Get("price_min")
+		maxPriceStr := query.Get("price_max")
+		sortBy := query.Get("sortBy")
 
-		// Конвертируем категории в int
-		var categoryIDs []int
-		for _, catStr := range categoryParams {
-			if catID, err := strconv.Atoi(catStr); err == nil {
-				categoryIDs = append(categoryIDs, catID)
-			}
-		}
+		filterParams, _ := filterParamsFromQuery(query)
 
-		// Конвертируем производителей в int
-		var producerIDs []int
-		for _, prodStr := range producerParams {
-			if prodID, err := strconv.Atoi(prodStr); err == nil {
-				producerIDs = append(producerIDs, prodID)
-			}
-		}
-
-		// Подготавливаем параметры для фильтрации
-		filterParams := &models.FilterParams{
-			SearchQuery: searchQuery,
-			Categories:  categoryIDs, // Используем правильное поле
-			Producers:   producerIDs, // Используем правильное поле
-		}
-
-		// Конвертируем цены
-		if minPriceStr != "" {
-			if minPrice, err := strconv.ParseFloat(minPriceStr, 64); err == nil {
-				filterParams.MinPrice = minPrice
-			}
-		}
-		if maxPriceStr != "" {
-			if maxPrice, err := strconv.ParseFloat(maxPriceStr, 64); err == nil {
-				filterParams.MaxPrice = maxPrice
-			}
-		}
-
-		// Сортировка
-		if sortBy == "ASC" || sortBy == "DESC" {
-			filterParams.SortByPrice = sortBy
-		}
 		// Получаем отфильтрованные продукты
-		products, err := database.GetFilteredProducts(db, r.Context(), filterParams)
+		result, err := searchCatalog(db, ctx, filterParams)
 		if err != nil {
 			log.Printf("Ошибка получения продуктов: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		products := result.Products
+
+		// Счетчики для боковой панели - по тем же фильтрам, включая поиск с опечатками
+		facets, err := database.GetCatalogFacets(db, ctx, filterParams, catalogPriceBounds)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 
 		// Подготавливаем данные для шаблона
 		data := models.CatalogData{
@@ -115,11 +74,40 @@ func serveCatalog(db *pgxpool.Pool) http.HandlerFunc {
 			Categories:         categoriesFromDB,
 			Producers:          producersFromDB,
 			SearchQuery:        searchQuery,
-			SelectedCategories: models.MakeStringSet(categoryParams),
-			SelectedBrands:     models.MakeStringSet(producerParams),
+			SelectedCategories: models.MakeStringSet(query["category"]),
+			SelectedBrands:     models.MakeStringSet(query["producer"]),
 			PriceMin:           minPriceStr,
 			PriceMax:           maxPriceStr,
 			SortBy:             sortBy,
+			Pagination:         newPagination(filterParams, result.Total, query),
+			SimilarResults:     filterParams.Fuzzy && result.Total > 0,
+			DidYouMean:         result.DidYouMean,
+			CategoryFacets:     make([]models.FacetOption, 0, len(categoriesFromDB)),
+			ProducerFacets:     make([]models.FacetOption, 0, len(producersFromDB)),
+			PriceBuckets:       priceBucketLinks(facets.PriceBuckets, filterParams, query),
+			InStockCount:       facets.InStock,
+			InStockOnly:        filterParams.InStockOnly,
+		}
+
+		for _, category := range categoriesFromDB {
+			data.CategoryFacets = append(data.CategoryFacets, models.FacetOption{
+				ID:       category.ID,
+				Name:     category.Name,
+				Count:    facets.Categories[category.ID],
+				Selected: data.SelectedCategories.Contains(strconv.Itoa(category.ID)),
+			})
+		}
+		for _, producer := range producersFromDB {
+			data.ProducerFacets = append(data.ProducerFacets, models.FacetOption{
+				ID:       producer.ID,
+				Name:     producer.Name,
+				Count:    facets.Producers[producer.ID],
+				Selected: data.SelectedBrands.Contains(strconv.Itoa(producer.ID)),
+			})
+		}
+
+		if result.DidYouMean != "" {
+			data.DidYouMeanURL = catalogSearchURL(query, result.DidYouMean)
 		}
 
 		// Логируем для отладки
@@ -137,3 +125,243 @@ func serveCatalog(db *pgxpool.Pool) http.HandlerFunc {
 
 	}
 }
+
+// catalogSearchResult - страница каталога и исправленный запрос, если искали с опечаткой
+type catalogSearchResult struct {
+	Products   []models.ProductForFilter
+	Total      int
+	DidYouMean string
+}
+
+// searchCatalog выбирает страницу каталога. Если поиск ничего не нашел, подбирает
+// исправленный запрос и повторяет поиск по сходству триграмм (params.Fuzzy = true)
+func searchCatalog(db *pgxpool.Pool, ctx context.Context, params *models.FilterParams) (*catalogSearchResult, error) {
+	result := &catalogSearchResult{}
+
+	total, err := database.CountFilteredProducts(db, ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if total == 0 && strings.TrimSpace(params.SearchQuery) != "" {
+		// Подсказка необязательна: ошибка не мешает показать похожие товары
+		suggestion, err := database.SuggestSearchQuery(db, ctx, params.SearchQuery)
+		if err == nil && suggestion != "" {
+			// Предлагаем исправление, только если по нему что-то найдется
+			corrected := *params
+			corrected.SearchQuery = suggestion
+			if found, err := database.CountFilteredProducts(db, ctx, &corrected); err == nil && found > 0 {
+				result.DidYouMean = suggestion
+			}
+		}
+
+		params.Fuzzy = true
+		if total, err = database.CountFilteredProducts(db, ctx, params); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Total = total
+	if total == 0 {
+		return result, nil
+	}
+
+	if result.Products, err = database.GetFilteredProducts(db, ctx, params); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// catalogSearchURL - ссылка на каталог с теми же фильтрами, но другим запросом, с первой страницы
+func catalogSearchURL(query url.Values, search string) string {
+	linkQuery := url.Values{}
+	for key, values := range query {
+		linkQuery[key] = values
+	}
+	linkQuery.Set("search", search)
+	linkQuery.Del("page")
+	return "/catalog?" + linkQuery.Encode()
+}
+
+// catalogPriceBounds - границы гистограммы цен в боковой панели, BYN
+var catalogPriceBounds = []float64{50, 100, 200, 500}
+
+// priceBucketLinks добавляет к диапазонам цен ссылки с теми же фильтрами.
+// Цена в БД с копейками, поэтому верхняя граница ссылки - Max минус копейка:
+// диапазон 50-100 не захватывает товары ровно за 100. Ссылка выбранного
+// диапазона снимает фильтр по цене.
+func priceBucketLinks(buckets []models.PriceBucket, params *models.FilterParams, query url.Values) []models.PriceBucket {
+	for i := range buckets {
+		priceMax := 0.0
+		if buckets[i].Max > 0 {
+			priceMax = math.Round(buckets[i].Max*100-1) / 100
+		}
+		buckets[i].Selected = params.MinPrice == buckets[i].Min && params.MaxPrice == priceMax
+
+		linkQuery := url.Values{}
+		for key, values := range query {
+			linkQuery[key] = values
+		}
+		linkQuery.Del("price_min")
+		linkQuery.Del("price_max")
+		linkQuery.Del("page")
+		if !buckets[i].Selected {
+			if buckets[i].Min > 0 {
+				linkQuery.Set("price_min", strconv.FormatFloat(buckets[i].Min, 'f', -1, 64))
+			}
+			if priceMax > 0 {
+				linkQuery.Set("price_max", strconv.FormatFloat(priceMax, 'f', 2, 64))
+			}
+		}
+		buckets[i].URL = "/catalog?" + linkQuery.Encode()
+	}
+	return buckets
+}
+
+// Размер страницы каталога по умолчанию и максимальный page_size.
+// Номер страницы ограничен, чтобы OFFSET не переполнялся
+const (
+	catalogPageSize    = 24
+	maxCatalogPageSize = 100
+	maxCatalogPage     = 10000
+)
+
+// filterParamsFromQuery разбирает фильтры каталога (search, category, producer,
+// price_min, price_max, in_stock, sortBy) и страницу (page, page_size). Некорректные значения
+// пропускаются, первое из них возвращается ошибкой: каталог ее игнорирует, API отвечает 400.
+func filterParamsFromQuery(query url.Values) (*models.FilterParams, error) {
+	var firstErr error
+	invalid := func(name, value string) {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("invalid value for %s: %q", name, value)
+		}
+	}
+
+	filterParams := &models.FilterParams{
+		SearchQuery: query.Get("search"),
+		Page:        1,
+		PageSize:    catalogPageSize,
+	}
+
+	// Конвертируем категории в int
+	for _, catStr := range query["category"] {
+		if catID, err := strconv.Atoi(catStr); err == nil {
+			filterParams.Categories = append(filterParams.Categories, catID)
+		} else {
+			invalid("category", catStr)
+		}
+	}
+
+	// Конвертируем производителей в int
+	for _, prodStr := range query["producer"] {
+		if prodID, err := strconv.Atoi(prodStr); err == nil {
+			filterParams.Producers = append(filterParams.Producers, prodID)
+		} else {
+			invalid("producer", prodStr)
+		}
+	}
+
+	// Конвертируем цены
+	if minPriceStr := query.Get("price_min"); minPriceStr != "" {
+		if minPrice, ok := parsePrice(minPriceStr); ok {
+			filterParams.MinPrice = minPrice
+		} else {
+			invalid("price_min", minPriceStr)
+		}
+	}
+	if maxPriceStr := query.Get("price_max"); maxPriceStr != "" {
+		if maxPrice, ok := parsePrice(maxPriceStr); ok {
+			filterParams.MaxPrice = maxPrice
+		} else {
+			invalid("price_max", maxPriceStr)
+		}
+	}
+
+	// Только в наличии
+	if inStockStr := query.Get("in_stock"); inStockStr != "" {
+		if inStock, err := strconv.ParseBool(inStockStr); err == nil {
+			filterParams.InStockOnly = inStock
+		} else {
+			invalid("in_stock", inStockStr)
+		}
+	}
+
+	// Сортировка
+	switch sortBy := query.Get("sortBy"); sortBy {
+	case "ASC", "DESC":
+		filterParams.SortByPrice = sortBy
+	case "":
+	default:
+		invalid("sortBy", sortBy)
+	}
+
+	// Страница
+	if pageStr := query.Get("page"); pageStr != "" {
+		if page, err := strconv.Atoi(pageStr); err == nil && page >= 1 && page <= maxCatalogPage {
+			filterParams.Page = page
+		} else {
+			invalid("page", pageStr)
+		}
+	}
+	if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+		if pageSize, err := strconv.Atoi(pageSizeStr); err == nil && pageSize >= 1 && pageSize <= maxCatalogPageSize {
+			filterParams.PageSize = pageSize
+		} else {
+			invalid("page_size", pageSizeStr)
+		}
+	}
+
+	return filterParams, firstErr
+}
+
+// parsePrice разбирает цену фильтра; NaN и бесконечность не принимаются
+func parsePrice(value string) (float64, bool) {
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+		return 0, false
+	}
+	return price, true
+}
+
+// newPagination собирает навигацию: первая и последняя страницы всегда видны,
+// вокруг текущей - по две соседних, остальное сворачивается в "…"
+func newPagination(params *models.FilterParams, total int, query url.Values) models.Pagination {
+	pagination := models.Pagination{
+		Page:       params.Page,
+		PageSize:   params.PageSize,
+		Total:      total,
+		TotalPages: (total + params.PageSize - 1) / params.PageSize,
+	}
+
+	// Ссылки сохраняют фильтры и меняют только page
+	pageURL := func(page int) string {
+		linkQuery := url.Values{}
+		for key, values := range query {
+			linkQuery[key] = values
+		}
+		linkQuery.Set("page", strconv.Itoa(page))
+		return "/catalog?" + linkQuery.Encode()
+	}
+
+	if params.Page > 1 {
+		pagination.PrevURL = pageURL(params.Page - 1)
+	}
+	if params.Page < pagination.TotalPages {
+		pagination.NextURL = pageURL(params.Page + 1)
+	}
+
+	for page := 1; page <= pagination.TotalPages; page++ {
+		if page == 1 || page == pagination.TotalPages || (page >= params.Page-2 && page <= params.Page+2) {
+			pagination.Links = append(pagination.Links, models.PageLink{
+				Page:    page,
+				URL:     pageURL(page),
+				Current: page == params.Page,
+			})
+		} else if links := pagination.Links; links[len(links)-1].Page != 0 {
+			pagination.Links = append(pagination.Links, models.PageLink{})
+		}
+	}
+
+	return pagination
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ServeEmailChangeVerifyPage - форма ввода кода, отправленного на новый email
+func ServeEmailChangeVerifyPage(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		user := CurrentPrincipal(r)
+
+		request, err := database.GetEmailChangeRequest(db, r.Context(), user.UserID)
+		if err != nil {
+			if errors.Is(err, database.ErrCodeNotFound) {
+				http.Redirect(w, r, "/update-profile-page", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := models.TemplateData{Email: request.NewEmail}
+		utils.RenderTemplate(w, "verify_email_change.html", data)
+	}
+}
+
+// EmailChangeVerifyHandler проверяет код и переключает аккаунт на новый email
+func EmailChangeVerifyHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		user := CurrentPrincipal(r)
+		ctx := r.Context()
+
+		request, err := database.UseEmailChangeCodeAttempt(db, ctx, user.UserID, maxCodeAttempts)
+		if err != nil {
+			if errors.Is(err, database.ErrCodeNotFound) {
+				showUpdateProfileError(w, db, ctx, user.UserID, "Запрос на смену email не найден, попробуйте еще раз")
+				return
+			}
+			data := models.TemplateData{Message: codeErrorMessage(err)}
+			if pending, err := database.GetEmailChangeRequest(db, ctx, user.UserID); err == nil {
+				data.Email = pending.NewEmail
+			}
+			utils.RenderTemplate(w, "verify_email_change.html", data)
+			return
+		}
+
+		data := models.TemplateData{Email: request.NewEmail}
+
+		// Каждая проверка засчитывается как попытка, даже с нечисловым кодом
+		enteredCode, err := strconv.Atoi(r.FormValue("code"))
+		if err != nil || enteredCode != request.VerificationCode {
+			data.Message = wrongCodeMessage(request.Attempts)
+			utils.RenderTemplate(w, "verify_email_change.html", data)
+			return
+		}
+
+		oldEmail, newEmail, err := database.ConfirmEmailChange(db, ctx, user.UserID)
+		if err != nil {
+			if errors.Is(err, database.ErrEmailTaken) {
+				_ = database.DeleteEmailChangeRequest(db, ctx, user.UserID)
+				showUpdateProfileError(w, db, ctx, user.UserID, "Пользователь с таким email уже существует")
+				return
+			}
+			data.Message = "Серверная ошибка, попробуйте еще раз"
+			utils.RenderTemplate(w, "verify_email_change.html", data)
+			return
+		}
+
+		log.Printf("Пользователь %d сменил email", user.UserID)
+		sendEmailChangedNotice(oldEmail, newEmail)
+
+		showUpdateProfileSuccess(w, db, ctx, user.UserID, "Email успешно изменен!")
+	}
+}
+
+// ResendEmailChangeCodeHandler повторно отправляет код на новый email
+func ResendEmailChangeCodeHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := CurrentPrincipal(r)
+		ctx := r.Context()
+
+		request, err := database.GetEmailChangeRequest(db, ctx, user.UserID)
+		if err != nil {
+			http.Redirect(w, r, "/update-profile-page", http.StatusSeeOther)
+			return
+		}
+
+		data := models.TemplateData{Email: request.NewEmail}
+
+		code, err := newVerificationCode()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.RenewEmailChangeCode(db, ctx, user.UserID, code, time.Now().Add(codeTTL), codeResendCooldown)
+		if err != nil {
+			data.Message = codeErrorMessage(err)
+			utils.RenderTemplate(w, "verify_email_change.html", data)
+			return
+		}
+
+		sendVerificationCode(request.NewEmail, code)
+
+		data.Success = true
+		data.Message = "Новый код отправлен на почту"
+		utils.RenderTemplate(w, "verify_email_change.html", data)
+	}
+}
+
+// CancelEmailChangeHandler отменяет ожидающую смену email
+func CancelEmailChangeHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := CurrentPrincipal(r)
+
+		if err := database.DeleteEmailChangeRequest(db, r.Context(), user.UserID); err != nil {
+			log.Println("Ошибка отмены смены email:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		showUpdateProfileSuccess(w, db, r.Context(), user.UserID, "Смена email отменена")
+	}
+}
+
+// sendEmailChangeNotice предупреждает прежний адрес о запросе смены email
+func sendEmailChangeNotice(oldEmail string, newEmail string) {
+	message := []byte("Subject: Запрос на смену email\r\n" +
+		"\r\n" + // Пустая строка между заголовками и телом
+		"Здравствуйте!\r\n" +
+		"Для вашего аккаунта запрошена смена email на " + newEmail + ".\r\n" +
+		"Адрес изменится только после ввода кода, отправленного на новый email.\r\n" +
+		"\r\n" +
+		"Если это были не вы, смените пароль и завершите все сессии в профиле.\r\n" +
+		"С уважением, Gain Wave.\r\n")
+
+	go SendCodeOnEmailAsync(oldEmail, message)
+}
+
+// sendEmailChangedNotice сообщает прежнему адресу, что email изменен
+func sendEmailChangedNotice(oldEmail string, newEmail string) {
+	message := []byte("Subject: Email аккаунта изменен\r\n" +
+		"\r\n" + // Пустая строка между заголовками и телом
+		"Здравствуйте!\r\n" +
+		"Email вашего аккаунта изменен на " + newEmail + ".\r\n" +
+		"\r\n" +
+		"Если это были не вы, обратитесь в поддержку.\r\n" +
+		"С уважением, Gain Wave.\r\n")
+
+	go SendCodeOnEmailAsync(oldEmail, message)
+}
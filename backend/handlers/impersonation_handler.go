@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Сессия входа под пользователем живет час и не продлевается refresh токеном
+const impersonationTTL = time.Hour
+
+// errImpersonationEnded - сотрудник больше не может оставаться под пользователем
+// (забанен или потерял право users.impersonate)
+var errImpersonationEnded = errors.New("impersonation is no longer allowed")
+
+// loadImpersonator проверяет сотрудника, открывшего сессию входа под пользователем.
+// Права перепроверяются на каждом запросе, как и у обычной сессии.
+func loadImpersonator(db *pgxpool.Pool, ctx context.Context, session *models.Session) (*models.User, error) {
+	staff, err := database.GetUser(db, ctx, session.ImpersonatorID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, errImpersonationEnded
+		}
+		return nil, err
+	}
+
+	if staff.AccountState != models.AccountActive {
+		return nil, errImpersonationEnded
+	}
+
+	_, permissions, err := database.GetUserAccess(db, ctx, staff.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !permissions.Contains(models.PermUsersImpersonate) {
+		return nil, errImpersonationEnded
+	}
+
+	return staff, nil
+}
+
+// denyImpersonation закрывает действия, которые сотрудник не должен
+// совершать от имени покупателя: пароль, удаление аккаунта, оплата и т.п.
+func denyImpersonation(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := CurrentPrincipal(r)
+
+		if principal.IsImpersonated() {
+			log.Printf("Сотрудник %d под пользователем %d: %s %s запрещено", principal.ImpersonatorID,
+				principal.UserID, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden: not available while signed in as a customer", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// AdminImpersonateHandler - вход сотрудника под покупателем. Сессия сотрудника
+// не закрывается: после выхода из режима он возвращается в нее.
+func AdminImpersonateHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx := r.Context()
+		principal := CurrentPrincipal(r)
+
+		fail := func(message string) {
+			redirectURL := "/admin?section=impersonate&message=" + url.QueryEscape(message) + "&success=false"
+			http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+		}
+
+		userID, _ := strconv.Atoi(r.FormValue("user_id"))
+		reason := strings.TrimSpace(r.FormValue("reason"))
+
+		if reason == "" || utf8.RuneCountInString(reason) > 255 {
+			fail("Укажите причину входа (до 255 символов)")
+			return
+		}
+
+		// Через API токен сессию не открыть: в cookie ее некуда положить
+		if principal.SessionID == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		target, err := database.GetUser(db, ctx, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrUserNotFound) {
+				fail("Пользователь не найден")
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if target.ID == principal.UserID {
+			fail("Нельзя войти под собственным аккаунтом")
+			return
+		}
+
+		if target.AccountState != models.AccountActive {
+			fail("Войти можно только под активным пользователем")
+			return
+		}
+
+		// Под сотрудниками не входим: это обошло бы их 2FA и расширило права
+		roles, _, err := database.GetUserAccess(db, ctx, target.ID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(roles) > 0 {
+			fail("Нельзя войти под сотрудником")
+			return
+		}
+
+		session, err := newSession(r, target.ID, impersonationTTL)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		session.ImpersonatorID = principal.UserID
+		session.ImpersonatorSessionID = principal.SessionID
+
+		if err := database.CreateSession(db, ctx, session); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		tokens, err := issueTokens(db, ctx, target, session.ID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		recordAudit(db, r, "impersonation.start", "user", strconv.Itoa(target.ID), nil, map[string]interface{}{
+			"email":      target.Email,
+			"reason":     reason,
+			"expires_at": session.ExpiresAt,
+		})
+
+		log.Printf("Сотрудник %d вошел под пользователем %d", principal.UserID, target.ID)
+
+		setAuthCookies(w, tokens)
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+	}
+}
+
+// StopImpersonationHandler - кнопка "Вернуться в админ-панель" на баннере
+func StopImpersonationHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		if !principal.IsImpersonated() {
+			http.Redirect(w, r, "/profile", http.StatusSeeOther)
+			return
+		}
+
+		session, err := database.GetActiveSession(db, r.Context(), principal.SessionID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, endImpersonation(db, w, r, session), http.StatusSeeOther)
+	}
+}
+
+// endImpersonation закрывает сессию входа под пользователем, пишет журнал
+// и возвращает сотрудника в его собственную сессию, если она еще жива.
+// Возвращает адрес для перенаправления.
+func endImpersonation(db *pgxpool.Pool, w http.ResponseWriter, r *http.Request, session *models.Session) string {
+	ctx := r.Context()
+
+	if err := database.RevokeSession(db, ctx, session.ID); err != nil {
+		log.Println("Ошибка отзыва сессии входа под пользователем:", err)
+	}
+
+	staff, err := database.GetUser(db, ctx, session.ImpersonatorID)
+	if err != nil {
+		utils.ClearCookie(w, r)
+		return "/"
+	}
+
+	recordAuditAs(db, r, staff.ID, staff.Email, "impersonation.stop", "user", strconv.Itoa(session.UserID), nil,
+		map[string]interface{}{
+			"duration": time.Since(session.CreatedAt).Round(time.Second).String(),
+		})
+
+	log.Printf("Сотрудник %d вышел из-под пользователя %d", staff.ID, session.UserID)
+
+	own, err := database.GetActiveSession(db, ctx, session.ImpersonatorSessionID)
+	if err != nil || own.UserID != staff.ID || staff.AccountState != models.AccountActive {
+		utils.ClearCookie(w, r)
+		return "/"
+	}
+
+	tokens, err := issueTokens(db, ctx, staff, own.ID)
+	if err != nil {
+		utils.ClearCookie(w, r)
+		return "/"
+	}
+
+	setAuthCookies(w, tokens)
+
+	return "/admin?section=impersonate&message=" + url.QueryEscape("Вы вышли из режима входа под пользователем") + "&success=true"
+}
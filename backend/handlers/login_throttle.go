@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// После скольких неудач подряд включается экспоненциальная задержка
+	loginBackoffAfter = 3
+	// Начальная и максимальная задержка между попытками входа
+	loginBackoffBase = time.Second
+	loginBackoffMax  = 5 * time.Minute
+	// Порог блокировки аккаунта и IP
+	loginLockEmailThreshold = 10
+	loginLockIPThreshold    = 50
+	// Длительность блокировки
+	loginLockDuration = 15 * time.Minute
+	// Через сколько времени без неудач счетчик начинается заново
+	loginFailureWindow = time.Hour
+)
+
+// loginThrottleKeys - субъекты, по которым считаются неудачные входы
+type loginThrottleKeys struct {
+	Email string
+	IP    string
+}
+
+func newLoginThrottleKeys(r *http.Request, email string) loginThrottleKeys {
+	return loginThrottleKeys{
+		Email: strings.ToLower(strings.TrimSpace(email)),
+		IP:    utils.ClientIP(r),
+	}
+}
+
+// loginRetryAfter возвращает, сколько еще ждать до следующей попытки
+func loginRetryAfter(throttle *models.LoginThrottle) time.Duration {
+	if throttle.Locked && throttle.LockedUntil != nil {
+		return time.Until(*throttle.LockedUntil)
+	}
+
+	if throttle.Failures < loginBackoffAfter {
+		return 0
+	}
+
+	// 1с, 2с, 4с, ... после каждой следующей неудачи
+	delay := loginBackoffBase * time.Duration(math.Pow(2, float64(throttle.Failures-loginBackoffAfter)))
+	if delay > loginBackoffMax || delay <= 0 {
+		delay = loginBackoffMax
+	}
+
+	return time.Until(throttle.LastFailureAt.Add(delay))
+}
+
+// checkLoginThrottle проверяет, можно ли сейчас пытаться войти.
+// Возвращает сообщение для пользователя, если попытка запрещена.
+func checkLoginThrottle(db *pgxpool.Pool, ctx context.Context, keys loginThrottleKeys) (string, error) {
+	subjects := []struct{ scope, subject string }{
+		{"email", keys.Email},
+		{"ip", keys.IP},
+	}
+
+	for _, s := range subjects {
+		throttle, err := database.GetLoginThrottle(db, ctx, s.scope, s.subject)
+		if err != nil {
+			return "", err
+		}
+
+		wait := loginRetryAfter(throttle)
+		if wait <= 0 {
+			continue
+		}
+
+		if throttle.Locked {
+			return fmt.Sprintf("Слишком много неудачных попыток входа. Вход временно заблокирован, попробуйте через %d мин.",
+				int(math.Ceil(wait.Minutes()))), nil
+		}
+
+		return fmt.Sprintf("Слишком много неудачных попыток входа. Попробуйте через %d сек.",
+			int(math.Ceil(wait.Seconds()))), nil
+	}
+
+	return "", nil
+}
+
+// recordLoginFailure засчитывает неудачный вход по email и IP.
+// При первой блокировке аккаунта владельцу уходит письмо.
+func recordLoginFailure(db *pgxpool.Pool, ctx context.Context, keys loginThrottleKeys, userExists bool) {
+	throttle, err := database.RecordLoginFailure(db, ctx, "email", keys.Email,
+		loginFailureWindow, loginLockEmailThreshold, loginLockDuration)
+	if err == nil && throttle.Failures == loginLockEmailThreshold {
+		log.Printf("Вход для %s заблокирован после %d неудачных попыток", keys.Email, throttle.Failures)
+		if userExists {
+			sendLockoutNotice(keys.Email, keys.IP, *throttle.LockedUntil)
+		}
+	}
+
+	throttle, err = database.RecordLoginFailure(db, ctx, "ip", keys.IP,
+		loginFailureWindow, loginLockIPThreshold, loginLockDuration)
+	if err == nil && throttle.Failures == loginLockIPThreshold {
+		log.Printf("Вход с IP %s заблокирован после %d неудачных попыток", keys.IP, throttle.Failures)
+	}
+}
+
+// clearLoginFailures сбрасывает счетчик аккаунта после успешного входа.
+// Счетчик IP не сбрасывается: иначе перебор чужих паролей можно
+// прерывать входом в свой аккаунт.
+func clearLoginFailures(db *pgxpool.Pool, ctx context.Context, keys loginThrottleKeys) {
+	if err := database.ClearLoginThrottle(db, ctx, "email", keys.Email); err != nil {
+		log.Println("Ошибка сброса счетчика входов:", err)
+	}
+}
+
+// sendLockoutNotice предупреждает владельца аккаунта о блокировке входа
+func sendLockoutNotice(email string, ip string, lockedUntil time.Time) {
+	message := []byte("Subject: Вход в аккаунт временно заблокирован\r\n" +
+		"\r\n" + // Пустая строка между заголовками и телом
+		"Здравствуйте!\r\n" +
+		"Мы зафиксировали несколько неудачных попыток входа в ваш аккаунт (IP: " + ip + ").\r\n" +
+		"Вход заблокирован до " + lockedUntil.Format("02.01.2006 15:04") + ".\r\n" +
+		"\r\n" +
+		"Если это были не вы, рекомендуем сменить пароль через восстановление пароля.\r\n" +
+		"С уважением, Gain Wave.\r\n")
+
+	go SendCodeOnEmailAsync(email, message)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Срок действия ссылки для входа
+	magicLinkTTL = 15 * time.Minute
+	// Как часто можно запрашивать новую ссылку на один аккаунт
+	magicLinkCooldown = 60 * time.Second
+)
+
+// magicLinkSentMessage показывается всегда, чтобы по ответу нельзя было
+// узнать, зарегистрирован ли email
+const magicLinkSentMessage = "Если аккаунт с таким email существует, мы отправили на него ссылку для входа"
+
+// ServeMagicLinkPage - форма запроса ссылки для входа
+func ServeMagicLinkPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("Неподдерживаемый метод %s", r.Method)
+		return
+	}
+
+	utils.RenderTemplate(w, "login_link.html", models.TemplateData{})
+}
+
+// MagicLinkHandler отправляет на email одноразовую ссылку для входа
+func MagicLinkHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		data := models.TemplateData{Email: r.FormValue("email")}
+
+		if !strings.Contains(data.Email, "@") || len(data.Email) > 255 {
+			data.Message = "Введите корректный email"
+			utils.RenderTemplate(w, "login_link.html", data)
+			return
+		}
+
+		ctx := r.Context()
+
+		// Заблокированный аккаунт или IP не получает и ссылку
+		throttleKeys := newLoginThrottleKeys(r, data.Email)
+		message, err := checkLoginThrottle(db, ctx, throttleKeys)
+		if err != nil {
+			data.Message = "Ошибка входа"
+			utils.RenderTemplate(w, "login_link.html", data)
+			return
+		}
+		if message != "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			data.Message = message
+			utils.RenderTemplate(w, "login_link.html", data)
+			return
+		}
+
+		data.Success = true
+		data.Message = magicLinkSentMessage
+
+		user, err := database.GetUserByEmail(db, ctx, data.Email)
+		if err != nil {
+			if !errors.Is(err, database.ErrUserNotFound) {
+				log.Println("Ошибка получения пользователя:", err)
+			}
+			utils.RenderTemplate(w, "login_link.html", data)
+			return
+		}
+
+		if user.AccountState != models.AccountActive {
+			utils.RenderTemplate(w, "login_link.html", data)
+			return
+		}
+
+		token, err := utils.NewRandomToken(32)
+		if err != nil {
+			log.Println("Ошибка генерации ссылки для входа:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.CreateMagicLinkToken(db, ctx, user.ID, utils.HashToken(token),
+			time.Now().Add(magicLinkTTL), magicLinkCooldown)
+		if err != nil {
+			if errors.Is(err, database.ErrCodeCooldown) {
+				// Предыдущая ссылка еще свежая - отвечаем так же, новое письмо не шлем
+				utils.RenderTemplate(w, "login_link.html", data)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		sendMagicLink(user.Email, token)
+
+		utils.RenderTemplate(w, "login_link.html", data)
+	}
+}
+
+// ServeMagicLinkVerifyPage - страница перехода по ссылке из письма.
+// Вход выполняется только по кнопке (POST): почтовые сканеры, открывающие
+// ссылки заранее, не должны расходовать одноразовый токен.
+func ServeMagicLinkVerifyPage(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		// Токен в URL не должен уходить на сторонние сайты через Referer
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		data := models.TemplateData{Token: r.URL.Query().Get("token")}
+
+		_, err := database.GetMagicLinkTokenUser(db, r.Context(), utils.HashToken(data.Token))
+		if err != nil {
+			data.Token = ""
+			data.Message = "Ссылка недействительна или устарела, запросите новую"
+		}
+
+		utils.RenderTemplate(w, "login_link_verify.html", data)
+	}
+}
+
+// MagicLinkVerifyHandler расходует ссылку и выполняет вход так же, как по паролю
+func MagicLinkVerifyHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		data := models.TemplateData{}
+		ctx := r.Context()
+
+		userID, err := database.UseMagicLinkToken(db, ctx, utils.HashToken(r.FormValue("token")))
+		if err != nil {
+			data.Message = "Ссылка недействительна или устарела, запросите новую"
+			utils.RenderTemplate(w, "login_link_verify.html", data)
+			return
+		}
+
+		user, err := database.GetUser(db, ctx, userID)
+		if err != nil {
+			data.Message = "Ошибка входа"
+			utils.RenderTemplate(w, "login_link_verify.html", data)
+			return
+		}
+
+		if user.AccountState != models.AccountActive {
+			data := models.TemplateData{FormType: "login", Message: inactiveAccountMessage(user.AccountState)}
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
+
+		err = finishLogin(db, w, r, user, newLoginThrottleKeys(r, user.Email))
+		if err != nil {
+			data.Message = "Ошибка входа"
+			utils.RenderTemplate(w, "login_link_verify.html", data)
+			return
+		}
+	}
+}
+
+// sendMagicLink отправляет письмо со ссылкой для входа
+func sendMagicLink(email string, token string) {
+	link := utils.AppConfig.BaseURL + "/login-link-verify-page?token=" + url.QueryEscape(token)
+
+	message := []byte("Subject: Ссылка для входа в GainWave\r\n" +
+		"\r\n" + // Пустая строка между заголовками и телом
+		"Здравствуйте!\r\n" +
+		"Чтобы войти в аккаунт, перейдите по ссылке:\r\n" +
+		link + "\r\n" +
+		"Ссылка одноразовая и действует " + strconv.Itoa(int(magicLinkTTL.Minutes())) + " минут.\r\n" +
+		"\r\n" +
+		"Если это были не вы, проигнорируйте это сообщение.\r\n" +
+		"С уважением, Gain Wave.\r\n")
+
+	go SendCodeOnEmailAsync(email, message)
+}
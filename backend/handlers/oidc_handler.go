@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Время на вход у внешнего провайдера
+const oidcStateTTL = 10 * time.Minute
+
+// oidcProvider - настроенный провайдер OpenID Connect (nil, если вход выключен)
+var oidcProvider *utils.OIDCProvider
+
+// oidcLoginState - данные, которые нужно сверить на обратном редиректе
+type oidcLoginState struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// setOIDCStateCookie сохраняет state, nonce и PKCE verifier в подписанной cookie
+func setOIDCStateCookie(w http.ResponseWriter, loginState *oidcLoginState) error {
+	signed, err := utils.SignJWT(jwt.MapClaims{
+		"purpose":  "oidc",
+		"state":    loginState.State,
+		"nonce":    loginState.Nonce,
+		"verifier": loginState.CodeVerifier,
+		"exp":      time.Now().Add(oidcStateTTL).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// SameSite=Lax: cookie нужна на обратном редиректе от провайдера (GET)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    signed,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   utils.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+	})
+
+	return nil
+}
+
+// readOIDCStateCookie проверяет подпись cookie и удаляет ее (state одноразовый)
+func readOIDCStateCookie(w http.ResponseWriter, r *http.Request) (*oidcLoginState, error) {
+	cookie, err := r.Cookie("oidc_state")
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    "",
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   utils.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+
+	claims, err := utils.ParseJWT(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims["purpose"] != "oidc" {
+		return nil, errors.New("невалидная cookie состояния входа")
+	}
+
+	loginState := &oidcLoginState{}
+	loginState.State, _ = claims["state"].(string)
+	loginState.Nonce, _ = claims["nonce"].(string)
+	loginState.CodeVerifier, _ = claims["verifier"].(string)
+
+	return loginState, nil
+}
+
+// OIDCLoginHandler отправляет пользователя на страницу входа провайдера
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("Неподдерживаемый метод %s", r.Method)
+		return
+	}
+
+	loginState := &oidcLoginState{}
+	for _, value := range []*string{&loginState.State, &loginState.Nonce, &loginState.CodeVerifier} {
+		token, err := utils.NewRandomToken(32)
+		if err != nil {
+			log.Println("Ошибка генерации состояния входа:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		*value = token
+	}
+
+	authURL, err := oidcProvider.AuthCodeURL(r.Context(), loginState.State, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		log.Println("Ошибка подключения к провайдеру OIDC:", err)
+		renderOIDCLoginError(w, "Вход через "+utils.AppConfig.OIDCProviderName+" временно недоступен")
+		return
+	}
+
+	if err := setOIDCStateCookie(w, loginState); err != nil {
+		log.Println("Ошибка сохранения состояния входа:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler принимает код от провайдера, находит или создает
+// пользователя и завершает вход так же, как вход по паролю
+func OIDCCallbackHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		query := r.URL.Query()
+		ctx := r.Context()
+
+		loginState, err := readOIDCStateCookie(w, r)
+		if err != nil || query.Get("state") == "" || query.Get("state") != loginState.State {
+			log.Println("Состояние входа OIDC не совпало:", err)
+			renderOIDCLoginError(w, "Время входа истекло, попробуйте еще раз")
+			return
+		}
+
+		if providerError := query.Get("error"); providerError != "" {
+			log.Printf("Провайдер OIDC вернул ошибку: %s %s", providerError, query.Get("error_description"))
+			renderOIDCLoginError(w, "Вход через "+utils.AppConfig.OIDCProviderName+" отменен")
+			return
+		}
+
+		claims, err := oidcProvider.Exchange(ctx, query.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+		if err != nil {
+			log.Println("Ошибка входа через OIDC:", err)
+			renderOIDCLoginError(w, "Не удалось войти через "+utils.AppConfig.OIDCProviderName)
+			return
+		}
+
+		user, message, err := oidcUser(db, r, claims)
+		if err != nil {
+			renderOIDCLoginError(w, "Ошибка входа")
+			return
+		}
+		if message != "" {
+			renderOIDCLoginError(w, message)
+			return
+		}
+
+		if user.AccountState != models.AccountActive {
+			renderOIDCLoginError(w, inactiveAccountMessage(user.AccountState))
+			return
+		}
+
+		err = finishLogin(db, w, r, user, newLoginThrottleKeys(r, user.Email))
+		if err != nil {
+			renderOIDCLoginError(w, "Ошибка входа")
+			return
+		}
+	}
+}
+
+// oidcUser возвращает пользователя для внешнего аккаунта: уже привязанного,
+// найденного по подтвержденному email или нового. Непустое сообщение
+// означает, что войти нельзя.
+func oidcUser(db *pgxpool.Pool, r *http.Request, claims *utils.OIDCClaims) (*models.User, string, error) {
+	ctx := r.Context()
+	provider := oidcProvider.Issuer
+
+	userID, err := database.GetIdentityUserID(db, ctx, provider, claims.Subject)
+	if err == nil {
+		user, err := database.GetUser(db, ctx, userID)
+		return user, "", err
+	}
+	if !errors.Is(err, database.ErrIdentityNotFound) {
+		return nil, "", err
+	}
+
+	// Привязывать и создавать аккаунты можно только по email, подтвержденному провайдером
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return nil, "Провайдер не подтвердил ваш email. Войдите с паролем или зарегистрируйтесь", nil
+	}
+
+	identity := &models.UserIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    email,
+	}
+
+	user, err := database.GetUserByEmail(db, ctx, email)
+	if err == nil {
+		identity.UserID = user.ID
+		if err := database.LinkIdentity(db, ctx, identity); err != nil {
+			return nil, "", err
+		}
+		log.Printf("Внешний аккаунт %s привязан к пользователю %d", provider, user.ID)
+		return user, "", nil
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return nil, "", err
+	}
+
+	// Новый пользователь: email уже подтвержден провайдером, код не нужен.
+	// Пароля нет - задать его можно через восстановление пароля.
+	user = &models.User{
+		Email:        email,
+		Name:         oidcUserName(claims),
+		AccountState: models.AccountActive,
+	}
+
+	if err := database.CreateUserWithIdentity(db, ctx, user, identity); err != nil {
+		return nil, "", err
+	}
+	log.Printf("Создан пользователь %d через внешний провайдер %s", user.ID, provider)
+
+	return user, "", nil
+}
+
+// oidcUserName - имя нового пользователя: из профиля провайдера или из email
+func oidcUserName(claims *utils.OIDCClaims) string {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	// Ограничение колонки users.name
+	for utf8.RuneCountInString(name) > 100 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	return name
+}
+
+func renderOIDCLoginError(w http.ResponseWriter, message string) {
+	data := models.TemplateData{FormType: "login", Message: message}
+	utils.RenderTemplate(w, "login.html", data)
+}
@@ -20,26 +20,11 @@ func ProcessPaymentHandler(db *pgxpool.Pool) http.HandlerFunc {
 			log.Printf("Неподдерживаемый метод %s", r.Method)
 			return
 		}
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		user := CurrentPrincipal(r)
 		ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
 		defer cancel()
 
-		orderID, err := database.CreateOrder(db, ctx, user.ID)
+		orderID, err := database.CreateOrder(db, ctx, user.UserID)
 
 		if err := ctx.Err(); err != nil {
 			log.Println("Контекст отменен", err)
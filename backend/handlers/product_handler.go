@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ProductPageHandler - GET /product/{ref}: страница товара по ID или по slug.
+// Slug заканчивается ID товара, поэтому устаревший slug (товар переименовали)
+// перенаправляет на актуальный адрес.
+func ProductPageHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			log.Printf("Неподдерживаемый метод %s для /product", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ref := r.PathValue("ref")
+
+		var product *models.ProductDetails
+		var err error
+		if productID, convErr := strconv.Atoi(ref); convErr == nil {
+			product, err = database.GetProductDetails(db, r.Context(), productID)
+		} else {
+			product, err = database.GetProductDetailsBySlug(db, r.Context(), ref)
+			if errors.Is(err, database.ErrProductNotFound) {
+				if current := productBySlugID(db, r, ref); current != nil {
+					http.Redirect(w, r, productURL(current.Slug), http.StatusMovedPermanently)
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, database.ErrProductNotFound) {
+				http.Error(w, "Product not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := models.ProductPageData{
+			Title:           product.Name + " - GainWave",
+			Product:         product,
+			SelectedVariant: selectedVariant(product, r.URL.Query().Get("variant")),
+		}
+
+		if err := utils.RenderTemplate(w, "product.html", data); err != nil {
+			log.Printf("Ошибка загрузки шаблона product: %v", err)
+			http.Error(w, "Product template not found", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// productBySlugID ищет товар по ID из конца slug ("...-whey-1")
+func productBySlugID(db *pgxpool.Pool, r *http.Request, slug string) *models.ProductDetails {
+	dash := strings.LastIndex(slug, "-")
+	if dash < 0 {
+		return nil
+	}
+
+	productID, err := strconv.Atoi(slug[dash+1:])
+	if err != nil {
+		return nil
+	}
+
+	product, err := database.GetProductDetails(db, r.Context(), productID)
+	if err != nil {
+		return nil
+	}
+	return product
+}
+
+// selectedVariant - вариант из ?variant=, иначе первый в наличии, иначе первый
+func selectedVariant(product *models.ProductDetails, requested string) *models.ProductVariant {
+	if len(product.Variants) == 0 {
+		return nil
+	}
+
+	if variantID, err := strconv.Atoi(requested); err == nil {
+		for i := range product.Variants {
+			if product.Variants[i].VariantID == variantID {
+				return &product.Variants[i]
+			}
+		}
+	}
+
+	for i := range product.Variants {
+		if product.Variants[i].StockQuantity > 0 {
+			return &product.Variants[i]
+		}
+	}
+	return &product.Variants[0]
+}
+
+// productURL - адрес страницы товара
+func productURL(slug string) string {
+	return "/product/" + url.PathEscape(slug)
+}
@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"online_store/database"
 	"online_store/models"
 	"online_store/utils"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -20,41 +22,36 @@ func ProfileHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		user := CurrentPrincipal(r)
+		ctx := r.Context()
 
-		user, err := validateToken(cookie.Value)
+		Orders, err := database.GetOrders(db, ctx, user.UserID)
 
 		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ctx := r.Context()
-		user, err = database.GetUser(db, ctx, user.ID) // Добавление имени в структуру user
-		if err != nil {
-			log.Println("Ошибка получения пользователя", err)
+			log.Println("Ошибка получения заказов", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		Orders, err := database.GetOrders(db, ctx, user.ID)
+
+		Sessions, err := database.GetUserSessions(db, ctx, user.UserID)
 
 		if err != nil {
-			log.Println("Ошибка получения заказов", err)
+			log.Println("Ошибка получения сессий", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
+		for i := range Sessions {
+			Sessions[i].Device = utils.DescribeDevice(Sessions[i].UserAgent)
+			Sessions[i].Current = Sessions[i].ID == user.SessionID
+		}
+
 		profile_data := models.ProfileData{
 			UserName:     user.Name,
 			UserEmail:    user.Email,
 			RecentOrders: Orders,
+			Sessions:     Sessions,
+			CanUseAPI:    len(apiTokenScopesFor(user)) > 0,
 		}
 		err = utils.RenderTemplate(w, "profile.html", profile_data)
 
@@ -75,31 +72,8 @@ func ServeChangeProfilePage(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		// Получаем текущего пользователя для отображения текущих данных
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		user := CurrentPrincipal(r)
 
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ctx := r.Context()
-		user, err = database.GetUser(db, ctx, user.ID) // Добавление имени в структуру users
-
-		if err != nil {
-			log.Println("Ошибка получения данных пользователя")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
 		// Передаем текущие данные пользователя в шаблон
 		data := struct {
 			CurrentName  string
@@ -111,7 +85,7 @@ func ServeChangeProfilePage(db *pgxpool.Pool) http.HandlerFunc {
 			CurrentEmail: user.Email,
 		}
 
-		err = utils.RenderTemplate(w, "update_profile.html", data)
+		err := utils.RenderTemplate(w, "update_profile.html", data)
 
 		if err != nil {
 			http.Error(w, "Template error", http.StatusInternalServerError)
@@ -130,24 +104,9 @@ func UpdateProfileHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
+		User := CurrentPrincipal(r)
 
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		User, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		err = r.ParseForm()
+		err := r.ParseForm()
 
 		if err != nil {
 			log.Println("ошибка парсинга формы", err)
@@ -160,48 +119,34 @@ func UpdateProfileHandler(db *pgxpool.Pool) http.HandlerFunc {
 		Password := r.FormValue("password")
 		ctx := r.Context()
 		if NewName == "" || NewEmail == "" || Password == "" {
-			showUpdateProfileError(w, db, ctx, User.ID, "Поля должны быть заполнены")
+			showUpdateProfileError(w, db, ctx, User.UserID, "Поля должны быть заполнены")
 			return
 		}
 
 		if len(NewName) > 100 {
-			showUpdateProfileError(w, db, ctx, User.ID, "Имя не должно быть длиннее 100 символов")
+			showUpdateProfileError(w, db, ctx, User.UserID, "Имя не должно быть длиннее 100 символов")
 			return
 		}
 
 		if !strings.Contains(NewEmail, "@") {
-			showUpdateProfileError(w, db, ctx, User.ID, "Email должен содержать @")
+			showUpdateProfileError(w, db, ctx, User.UserID, "Email должен содержать @")
 			return
 		}
 
 		if len(NewEmail) > 255 {
-			showUpdateProfileError(w, db, ctx, User.ID, "Email не должен быть длиннее 255 символов")
+			showUpdateProfileError(w, db, ctx, User.UserID, "Email не должен быть длиннее 255 символов")
 			return
 		}
 
 		if len(Password) < 6 {
-			showUpdateProfileError(w, db, ctx, User.ID, "Пароль должен содержать минимум 6 символов")
+			showUpdateProfileError(w, db, ctx, User.UserID, "Пароль должен содержать минимум 6 символов")
 			return
 		}
 
-		// Проверяем, нет ли уже пользователя
-		if NewEmail != User.Email {
-			existingUser, err := database.GetUserByEmail(db, ctx, NewEmail)
-			if err != nil {
-				log.Print("Ошибка получения пользователя по Email", err)
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			if existingUser.Email != NewEmail {
-				showUpdateProfileError(w, db, ctx, User.ID, "Пользователь с таким email уже существует")
-				return
-			}
-		}
-
-		user, err := database.GetUser(db, ctx, User.ID)
+		user, err := database.GetUser(db, ctx, User.UserID)
 
 		if err != nil {
-			showUpdateProfileError(w, db, ctx, user.ID, "Серверная ошибка, попробуйте еще раз")
+			showUpdateProfileError(w, db, ctx, User.UserID, "Серверная ошибка, попробуйте еще раз")
 			log.Println("Ошибка получения данных пользователя: ", err)
 			return
 		}
@@ -211,30 +156,61 @@ func UpdateProfileHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		quary := "UPDATE users SET email = $1, name = $2 WHERE id = $3"
+		// Проверяем, не занят ли новый email другим аккаунтом
+		if NewEmail != user.Email {
+			_, err := database.GetUserByEmail(db, ctx, NewEmail)
+			if err == nil {
+				showUpdateProfileError(w, db, ctx, user.ID, "Пользователь с таким email уже существует")
+				return
+			}
+			if !errors.Is(err, database.ErrUserNotFound) {
+				log.Print("Ошибка получения пользователя по Email", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
 
-		_, err = db.Exec(ctx, quary, NewEmail, NewName, user.ID)
+		// Email меняется только после подтверждения с нового адреса. Запрос
+		// создаем до смены имени: если он не пройдет (например, из-за паузы
+		// между письмами), профиль останется прежним
+		emailChanged := NewEmail != user.Email
+		var code int
+		if emailChanged {
+			code, err = newVerificationCode()
+			if err != nil {
+				showUpdateProfileError(w, db, ctx, user.ID, "Серверная ошибка, попробуйте еще раз")
+				return
+			}
 
-		if err != nil {
-			showUpdateProfileError(w, db, ctx, user.ID, "Серверная ошибка, попробуйте еще раз")
-			log.Println("Ошибка обновления данных профиля: ", err)
-			return
+			err = database.CreateEmailChangeRequest(db, ctx, user.ID, NewEmail, code, time.Now().Add(codeTTL), codeResendCooldown)
+			if err != nil {
+				showUpdateProfileError(w, db, ctx, user.ID, codeErrorMessage(err))
+				return
+			}
 		}
 
-		if NewEmail != User.Email {
-			newToken, err := generateToken(user.ID, NewEmail, user.Status)
-			if err == nil {
-				http.SetCookie(w, &http.Cookie{
-					Name:     "auth_token",
-					Value:    newToken,
-					Path:     "/",
-					HttpOnly: true,
-					MaxAge:   24 * 60 * 60,
-				})
+		// Имя меняется сразу
+		if NewName != user.Name {
+			quary := "UPDATE users SET name = $1 WHERE id = $2"
+
+			_, err = db.Exec(ctx, quary, NewName, user.ID)
+
+			if err != nil {
+				showUpdateProfileError(w, db, ctx, user.ID, "Серверная ошибка, попробуйте еще раз")
+				log.Println("Ошибка обновления данных профиля: ", err)
+				return
 			}
 		}
 
-		showUpdateProfileSuccess(w, db, ctx, user.ID, "Данные были успешно изменены!")
+		if !emailChanged {
+			showUpdateProfileSuccess(w, db, ctx, user.ID, "Данные были успешно изменены!")
+			return
+		}
+
+		sendVerificationCode(NewEmail, code)
+		sendEmailChangeNotice(user.Email, NewEmail)
+
+		http.Redirect(w, r, "/update-email-verify-page", http.StatusSeeOther)
 	}
 }
 
@@ -303,24 +279,6 @@ func ServeChangePassword(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		// Получаем текущего пользователя для отображения текущих данных
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		_, err = validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
 		data := struct {
 			Message string
 			Success bool
@@ -329,7 +287,7 @@ func ServeChangePassword(db *pgxpool.Pool) http.HandlerFunc {
 			Success: false,
 		}
 
-		err = utils.RenderTemplate(w, "change_password.html", data)
+		err := utils.RenderTemplate(w, "change_password.html", data)
 
 		if err != nil {
 			http.Error(w, "Template not found", http.StatusInternalServerError)
@@ -348,24 +306,9 @@ func ChangePasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		principal := CurrentPrincipal(r)
 
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		err = r.ParseForm()
+		err := r.ParseForm()
 
 		if err != nil {
 			log.Println("ошибка парсинга формы", err)
@@ -390,19 +333,13 @@ func ChangePasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		if len(CurrentPassword) < 6 || len(NewPassword) < 6 || len(ConfirmPassword) < 6 {
-			data.Message = "Пароль должен содержать минимум 6 символов"
-			utils.RenderTemplate(w, "change_password.html", data)
-			return
-		}
-
 		if ConfirmPassword != NewPassword {
 			data.Message = "Пароли должны быть одинаковыми"
 			utils.RenderTemplate(w, "change_password.html", data)
 			return
 		}
 
-		user, err = database.GetUser(db, ctx, user.ID) // Дополнение user для получения password
+		user, err := database.GetUser(db, ctx, principal.UserID) // Дополнение user для получения password
 
 		if err != nil {
 			data.Message = "Серверная ошибка, попробуйте еще раз"
@@ -416,6 +353,12 @@ func ChangePasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		if err := utils.ValidatePassword(NewPassword, user.Email, user.Name); err != nil {
+			data.Message = err.Error()
+			utils.RenderTemplate(w, "change_password.html", data)
+			return
+		}
+
 		NewPasswordHash, err := HashPassword(NewPassword)
 
 		if err != nil {
@@ -434,6 +377,12 @@ func ChangePasswordHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
+		// Пароль сменен - завершаем все сессии, включая текущую
+		err = database.RevokeUserSessions(db, ctx, user.ID)
+		if err != nil {
+			log.Println("Ошибка отзыва сессий пользователя:", err)
+		}
+
 		data.Success = true
 		data.Message = "Пароль был успешно изменен!"
 		utils.ClearCookie(w, r)
@@ -450,23 +399,7 @@ func DeleteAccountPage(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		// Получаем текущего пользователя для отображения текущих данных
-		cookie, err := r.Cookie("auth_token")
-
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		user, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		user := CurrentPrincipal(r)
 
 		data := struct {
 			UserEmail string
@@ -477,7 +410,7 @@ func DeleteAccountPage(db *pgxpool.Pool) http.HandlerFunc {
 			Message:   "",
 			Success:   false,
 		}
-		err = utils.RenderTemplate(w, "delete_account.html", data)
+		err := utils.RenderTemplate(w, "delete_account.html", data)
 		if err != nil {
 			log.Println("Ошибка парсинга html файла удаления аккаунта пользователя")
 			http.Error(w, "Template rendering error", http.StatusInternalServerError)
@@ -496,24 +429,9 @@ func DeleteAccountHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		cookie, err := r.Cookie("auth_token")
+		User := CurrentPrincipal(r)
 
-		if err != nil || cookie.Value == "" {
-			log.Println("Ошибка получения Cookie")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		User, err := validateToken(cookie.Value)
-
-		if err != nil {
-			log.Println("Ошибка валидации токена", err)
-			utils.ClearCookie(w, r)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		err = r.ParseForm()
+		err := r.ParseForm()
 
 		if err != nil {
 			log.Println("ошибка парсинга формы", err)
@@ -557,7 +475,7 @@ func DeleteAccountHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		user, err := database.GetUser(db, ctx, User.ID)
+		user, err := database.GetUser(db, ctx, User.UserID)
 
 		if err != nil {
 			data.Message = "Серверная ошибка, попробуйте еще раз"
@@ -571,10 +489,8 @@ func DeleteAccountHandler(db *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		//Каскадное удаление с users, cart, cart_items, orders, order_items
-		quary := "Delete FROM users WHERE id = $1"
-
-		_, err = db.Exec(ctx, quary, user.ID)
+		// Мягкое удаление: заказы остаются, персональные данные стираются
+		err = database.DeleteUser(db, ctx, user.ID)
 
 		if err != nil {
 			data.Message = "Серверная ошибка, попробуйте еще раз"
@@ -587,3 +503,67 @@ func DeleteAccountHandler(db *pgxpool.Pool) http.HandlerFunc {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
 }
+
+// RevokeSessionHandler завершает одну сессию пользователя (кнопка "Выйти" у устройства)
+func RevokeSessionHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		user := CurrentPrincipal(r)
+
+		err := r.ParseForm()
+		if err != nil {
+			log.Println("ошибка парсинга формы", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		sessionID := r.FormValue("session_id")
+		if sessionID == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		err = database.RevokeUserSession(db, r.Context(), user.UserID, sessionID)
+		if err != nil {
+			log.Println("Ошибка отзыва сессии:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if sessionID == user.SessionID {
+			utils.ClearCookie(w, r)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+	}
+}
+
+// RevokeAllSessionsHandler - "выйти на всех устройствах", включая текущее
+func RevokeAllSessionsHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			log.Printf("Неподдерживаемый метод %s", r.Method)
+			return
+		}
+
+		user := CurrentPrincipal(r)
+
+		err := database.RevokeUserSessions(db, r.Context(), user.UserID)
+		if err != nil {
+			log.Println("Ошибка отзыва сессий пользователя:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		utils.ClearCookie(w, r)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
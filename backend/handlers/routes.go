@@ -1,55 +1,300 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// principalKey - ключ для хранения Principal в контексте запроса
+type principalKey struct{}
+
+// Middleware - обертка над обработчиком
+type Middleware func(http.HandlerFunc) http.HandlerFunc
+
+// RequireUser проверяет access JWT (Bearer или cookie, с автообновлением по
+// refresh токену), загружает пользователя из БД, отклоняет забаненных
+// и кладет Principal в контекст запроса
+func RequireUser(db *pgxpool.Pool) Middleware {
+	return requirePrincipal(db, false)
+}
+
+// requirePrincipal - общая часть RequireUser и RequirePermission.
+// Персональные API токены принимаются только при allowAPITokens: ими нельзя
+// управлять профилем, корзиной или самими токенами.
+func requirePrincipal(db *pgxpool.Pool, allowAPITokens bool) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if rawToken, ok := apiTokenFromRequest(r); ok && allowAPITokens {
+				principal, status := authenticateAPIToken(db, r, rawToken)
+				if principal == nil {
+					http.Error(w, http.StatusText(status), status)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), principalKey{}, principal)
+				next(w, r.WithContext(ctx))
+				return
+			}
+
+			claims, err := authenticateRequest(db, w, r)
+
+			if err != nil {
+				log.Println("Ошибка аутентификации:", err)
+				utils.ClearCookie(w, r)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := r.Context()
+
+			// Токен валиден, только пока жива серверная сессия
+			session, err := database.GetActiveSession(db, ctx, claims.SessionID)
+			if err != nil || session.UserID != claims.UserID {
+				if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+					log.Println("Ошибка получения сессии", err)
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				log.Println("Сессия отозвана или истекла", claims.SessionID)
+				utils.ClearCookie(w, r)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if err := database.TouchSession(db, ctx, session.ID); err != nil {
+				log.Println("Ошибка обновления активности сессии", err)
+			}
+
+			user, err := database.GetUser(db, ctx, claims.UserID)
+			if err != nil {
+				if errors.Is(err, database.ErrUserNotFound) {
+					log.Println("Пользователь из токена не найден", claims.UserID)
+					utils.ClearCookie(w, r)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				log.Println("Ошибка получения пользователя", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			switch user.AccountState {
+			case models.AccountBanned:
+				log.Printf("Забаненный пользователь %d пытается получить доступ", user.ID)
+				utils.ClearCookie(w, r)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			case models.AccountDeleted:
+				log.Printf("Удаленный пользователь %d пытается получить доступ", user.ID)
+				utils.ClearCookie(w, r)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			roles, permissions, err := database.GetUserAccess(db, ctx, user.ID)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			principal := &models.Principal{
+				UserID:      user.ID,
+				Email:       user.Email,
+				Name:        user.Name,
+				SessionID:   session.ID,
+				Roles:       roles,
+				Permissions: permissions,
+
+				TwoFactorEnabled: user.TOTPEnabled,
+			}
+
+			// Сотрудник под пользователем: помечаем запрос и показываем баннер
+			if session.ImpersonatorID != 0 {
+				staff, err := loadImpersonator(db, ctx, session)
+				if err != nil {
+					if !errors.Is(err, errImpersonationEnded) {
+						http.Error(w, "Internal server error", http.StatusInternalServerError)
+						return
+					}
+					log.Printf("Сотрудник %d больше не может входить под пользователем", session.ImpersonatorID)
+					if err := database.RevokeSession(db, ctx, session.ID); err != nil {
+						log.Println("Ошибка отзыва сессии:", err)
+					}
+					utils.ClearCookie(w, r)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				principal.ImpersonatorID = staff.ID
+				principal.ImpersonatorEmail = staff.Email
+
+				w = utils.WithImpersonation(w, &utils.Impersonation{
+					StaffEmail: staff.Email,
+					UserEmail:  user.Email,
+					UserName:   user.Name,
+				})
+			}
+
+			ctx = context.WithValue(ctx, principalKey{}, principal)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// RequirePermission пропускает только пользователей, у роли которых есть
+// указанное право (например "products.write"). Кроме сессии принимает
+// персональный API токен со scope, равным этому праву.
+func RequirePermission(db *pgxpool.Pool, permission string) Middleware {
+	requireUser := requirePrincipal(db, true)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return requireUser(func(w http.ResponseWriter, r *http.Request) {
+			principal := CurrentPrincipal(r)
+
+			if !principal.Can(permission) {
+				log.Printf("Пользователь %d не имеет права %s", principal.UserID, permission)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			// Сотрудникам без 2FA админка закрыта до ее включения
+			if principal.IsStaff() && !principal.TwoFactorEnabled {
+				log.Printf("Сотрудник %d без 2FA, перенаправляем на настройку", principal.UserID)
+				http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+				return
+			}
+
+			next(w, r)
+		})
+	}
+}
+
+// CurrentPrincipal возвращает пользователя, положенного в контекст middleware.
+// Вызывается только из обработчиков, обернутых в RequireUser/RequirePermission.
+func CurrentPrincipal(r *http.Request) *models.Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*models.Principal)
+	return principal
+}
+
 func RegisterRoutes(mux *http.ServeMux, db *pgxpool.Pool) {
+	requireUser := RequireUser(db)
+	requireAdminAccess := RequirePermission(db, models.PermAdminAccess)
+	requireProductsWrite := RequirePermission(db, models.PermProductsWrite)
+	requireReportsRead := RequirePermission(db, models.PermReportsRead)
+	requireUsersBan := RequirePermission(db, models.PermUsersBan)
+	requireSecurityManage := RequirePermission(db, models.PermSecurityManage)
+	requireRolesManage := RequirePermission(db, models.PermRolesManage)
+	requireAuditRead := RequirePermission(db, models.PermAuditRead)
+	requireUsersImpersonate := RequirePermission(db, models.PermUsersImpersonate)
+
+	// Действия, недоступные сотруднику, вошедшему под пользователем
+	requireAccountOwner := func(next http.HandlerFunc) http.HandlerFunc {
+		return requireUser(denyImpersonation(next))
+	}
+
 	// Маршруты
 
 	mux.HandleFunc("/", serveLoginPage)
 	mux.HandleFunc("/login", loginFormHandler(db))
+	mux.HandleFunc("/login-2fa-page", ServeLogin2FAPage)                    // GET - форма кода 2FA
+	mux.HandleFunc("/login-2fa", Login2FAHandler(db))                       // POST - проверить код 2FA
+	mux.HandleFunc("/login-link-page", ServeMagicLinkPage)                  // GET - форма запроса ссылки
+	mux.HandleFunc("/login-link", MagicLinkHandler(db))                     // POST - отправить ссылку для входа
+	mux.HandleFunc("/login-link-verify-page", ServeMagicLinkVerifyPage(db)) // GET - переход по ссылке из письма
+	mux.HandleFunc("/login-link-verify", MagicLinkVerifyHandler(db))        // POST - войти по ссылке
 	mux.HandleFunc("/register", registerFormHandler(db))
 
+	// Вход через внешний OpenID Connect провайдер, если он настроен
+	if utils.AppConfig.OIDCIssuer != "" {
+		oidcProvider = utils.NewOIDCProvider(utils.AppConfig.OIDCIssuer, utils.AppConfig.OIDCClientID,
+			utils.AppConfig.OIDCClientSecret, utils.AppConfig.OIDCDiscoveryURL, utils.AppConfig.OIDCRedirectURL)
+
+		mux.HandleFunc("/auth/oidc/login", OIDCLoginHandler)           // GET - редирект к провайдеру
+		mux.HandleFunc("/auth/oidc/callback", OIDCCallbackHandler(db)) // GET - возврат от провайдера
+	}
+
 	mux.HandleFunc("/forgot-password", ServeForgotPasswordPage(db))                                    // GET - форма email
 	mux.HandleFunc("/forgot-password-send", ForgotPasswordHandler(db))                                 // POST - отправить код
 	mux.HandleFunc("/forgot-password-verify-page", ServeForgotPasswordVerifyPage(db))                  // GET - форма кода
 	mux.HandleFunc("/forgot-password-verify", ForgotPasswordVerifyHandler(db))                         // POST - проверить код
+	mux.HandleFunc("/forgot-password-resend", ResendForgotPasswordCodeHandler(db))                     // POST - отправить код повторно
 	mux.HandleFunc("/forgot-password-update-password-page", ServeForgotPasswordUpdatePasswordPage(db)) // GET - форма пароля
 	mux.HandleFunc("/forgot-password-update-password", ForgotPasswordUpdatePasswordHandler(db))        // POST - сохранить пароль
 
 	mux.HandleFunc("/verify-code-page", ServeVerifyCodePage(db))
 	mux.HandleFunc("/verify-code", verifyCodeHandler(db))
-	mux.HandleFunc("/logout", logoutHandler)
-	mux.HandleFunc("/catalog", serveCatalog(db)) // Передаем db в обработчик
-	mux.HandleFunc("/add-to-cart", AddProductToCartHandler(db))
-	mux.HandleFunc("/cart", CartHandler(db))
-	mux.HandleFunc("/delete-from-cart", DeleteProductFromCartHandler(db))
-	mux.HandleFunc("/profile", ProfileHandler(db))
+	mux.HandleFunc("/verify-code-resend", ResendVerificationCodeHandler(db))
+	mux.HandleFunc("/logout", logoutHandler(db))
+	mux.HandleFunc("/auth/refresh", RefreshTokenHandler(db))
+	mux.HandleFunc("/.well-known/jwks.json", JWKSHandler)     // GET - публичные ключи JWT
+	mux.HandleFunc("/catalog", requireUser(serveCatalog(db))) // Передаем db в обработчик
+
+	// Страница товара: /product/42 или /product/optimum-nutrition-...-42
+	mux.HandleFunc("/product/{ref}", requireUser(ProductPageHandler(db)))
+
+	// Публичный JSON API каталога для мобильного приложения и партнеров
+	mux.HandleFunc("/api/v1/products", APIProductsHandler(db))
+	mux.HandleFunc("/api/v1/products/{id}", APIProductHandler(db))
+	mux.HandleFunc("/api/v1/categories", APICategoriesHandler(db))
+	mux.HandleFunc("/api/v1/producers", APIProducersHandler(db))
+	mux.HandleFunc("/api/v1/", APINotFoundHandler)
+	mux.HandleFunc("/search/suggest", SearchSuggestHandler(db)) // GET - подсказки строки поиска
+
+	mux.HandleFunc("/add-to-cart", requireUser(AddProductToCartHandler(db)))
+	mux.HandleFunc("/cart", requireUser(CartHandler(db)))
+	mux.HandleFunc("/delete-from-cart", requireUser(DeleteProductFromCartHandler(db)))
+	mux.HandleFunc("/profile", requireUser(ProfileHandler(db)))
+	mux.HandleFunc("/profile/sessions/revoke", requireAccountOwner(RevokeSessionHandler(db)))
+	mux.HandleFunc("/profile/sessions/revoke-all", requireAccountOwner(RevokeAllSessionsHandler(db)))
+	mux.HandleFunc("/profile/2fa", requireAccountOwner(TwoFactorPageHandler(db)))
+	mux.HandleFunc("/profile/2fa/new-secret", requireAccountOwner(NewTwoFactorSecretHandler(db)))
+	mux.HandleFunc("/profile/2fa/enable", requireAccountOwner(EnableTwoFactorHandler(db)))
+	mux.HandleFunc("/profile/2fa/disable", requireAccountOwner(DisableTwoFactorHandler(db)))
+	mux.HandleFunc("/profile/2fa/recovery-codes", requireAccountOwner(RegenerateRecoveryCodesHandler(db)))
+	mux.HandleFunc("/profile/tokens", requireUser(APITokensPageHandler(db)))                 // GET - персональные API токены
+	mux.HandleFunc("/profile/tokens/create", requireAccountOwner(CreateAPITokenHandler(db))) // POST - выпустить токен
+	mux.HandleFunc("/profile/tokens/revoke", requireAccountOwner(RevokeAPITokenHandler(db))) // POST - отозвать свой токен
+	mux.HandleFunc("/impersonation/stop", requireUser(StopImpersonationHandler(db)))         // POST - выйти из режима входа под пользователем
 
-	mux.HandleFunc("/update-profile-page", ServeChangeProfilePage(db))
-	mux.HandleFunc("/update-profile", UpdateProfileHandler(db))
+	mux.HandleFunc("/update-profile-page", requireUser(ServeChangeProfilePage(db)))
+	mux.HandleFunc("/update-profile", requireAccountOwner(UpdateProfileHandler(db)))
+	mux.HandleFunc("/update-email-verify-page", requireUser(ServeEmailChangeVerifyPage(db)))      // GET - форма кода с нового email
+	mux.HandleFunc("/update-email-verify", requireAccountOwner(EmailChangeVerifyHandler(db)))     // POST - подтвердить смену email
+	mux.HandleFunc("/update-email-resend", requireAccountOwner(ResendEmailChangeCodeHandler(db))) // POST - отправить код повторно
+	mux.HandleFunc("/update-email-cancel", requireAccountOwner(CancelEmailChangeHandler(db)))     // POST - отменить смену email
 
-	mux.HandleFunc("/change-password-page", ServeChangePassword(db))
-	mux.HandleFunc("/change-password", ChangePasswordHandler(db))
+	mux.HandleFunc("/change-password-page", requireAccountOwner(ServeChangePassword(db)))
+	mux.HandleFunc("/change-password", requireAccountOwner(ChangePasswordHandler(db)))
 
-	mux.HandleFunc("/delete-account-page", DeleteAccountPage(db))
-	mux.HandleFunc("/delete-account", DeleteAccountHandler(db))
+	mux.HandleFunc("/delete-account-page", requireAccountOwner(DeleteAccountPage(db)))
+	mux.HandleFunc("/delete-account", requireAccountOwner(DeleteAccountHandler(db)))
 
-	mux.HandleFunc("/process-payment", ProcessPaymentHandler(db))
+	mux.HandleFunc("/process-payment", requireAccountOwner(ProcessPaymentHandler(db)))
 	mux.HandleFunc("/success-payment", SuccessPaymentHandler())
 	mux.HandleFunc("/error-payment", ErrorPaymentHandler())
 
-	mux.HandleFunc("/admin", AdminPanelHandler(db))
-	mux.HandleFunc("/admin/products/save", AdminSaveProductHandler(db))
-	mux.HandleFunc("/admin/products/delete", AdminDeleteProductHandler(db))
-	mux.HandleFunc("/admin/users/ban", AdminBanUserHandler(db))
-	mux.HandleFunc("/admin/users/unban", AdminUnbanUserHandler(db))
-	mux.HandleFunc("/admin/report", GenerateReportHandler(db))
+	mux.HandleFunc("/admin", requireAdminAccess(AdminPanelHandler(db)))
+	mux.HandleFunc("/admin/products/save", requireProductsWrite(AdminSaveProductHandler(db)))
+	mux.HandleFunc("/admin/products/delete", requireProductsWrite(AdminDeleteProductHandler(db)))
+	mux.HandleFunc("/admin/users/ban", requireUsersBan(AdminBanUserHandler(db)))
+	mux.HandleFunc("/admin/users/unban", requireUsersBan(AdminUnbanUserHandler(db)))
+	mux.HandleFunc("/admin/roles/assign", requireRolesManage(AdminAssignRoleHandler(db)))
+	mux.HandleFunc("/admin/roles/remove", requireRolesManage(AdminRemoveRoleHandler(db)))
+	mux.HandleFunc("/admin/audit/export-csv", requireAuditRead(ExportAuditCSVHandler(db)))
+	mux.HandleFunc("/admin/lockouts/clear", requireSecurityManage(AdminClearLockoutHandler(db)))
+	mux.HandleFunc("/admin/tokens/revoke", requireSecurityManage(AdminRevokeAPITokenHandler(db)))
+	mux.HandleFunc("/admin/impersonate", requireUsersImpersonate(AdminImpersonateHandler(db)))
+	mux.HandleFunc("/admin/report", requireReportsRead(GenerateReportHandler(db)))
 
-	mux.HandleFunc("/admin/export-csv", ExportProductsCSVHandler(db))
-	mux.HandleFunc("/admin/import-csv", ImportProductsCSVHandler(db))
+	mux.HandleFunc("/admin/export-csv", requireReportsRead(ExportProductsCSVHandler(db)))
+	mux.HandleFunc("/admin/import-csv", requireProductsWrite(ImportProductsCSVHandler(db)))
 
 }
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"online_store/database"
+	"online_store/models"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Подсказки поиска: до suggestLimit в каждой группе, запрос от 2 до 100 символов
+const (
+	suggestLimit          = 5
+	minSuggestQueryLength = 2
+	maxSuggestQueryLength = 100
+)
+
+// SearchSuggestHandler - GET /search/suggest?q=: товары, бренды и категории
+// для строки поиска каталога. Ответ в том же конверте, что и JSON API.
+func SearchSuggestHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiGetOnly(w, r) {
+			return
+		}
+
+		searchQuery := strings.TrimSpace(r.URL.Query().Get("q"))
+		length := utf8.RuneCountInString(searchQuery)
+		if length > maxSuggestQueryLength {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidParameter, "query is too long")
+			return
+		}
+
+		// По одной букве подсказывать нечего
+		if length < minSuggestQueryLength {
+			writeAPIData(w, &models.SearchSuggestions{
+				Products:   []models.SearchSuggestion{},
+				Producers:  []models.SearchSuggestion{},
+				Categories: []models.SearchSuggestion{},
+			}, nil)
+			return
+		}
+
+		suggestions, err := database.GetSearchSuggestions(db, r.Context(), searchQuery, suggestLimit)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal server error")
+			return
+		}
+
+		// Товар ведет на свою страницу, бренд и категория - в каталог с фильтром
+		for i := range suggestions.Products {
+			suggestions.Products[i].URL = "/product/" + strconv.Itoa(suggestions.Products[i].ID)
+		}
+		for i := range suggestions.Producers {
+			suggestions.Producers[i].URL = "/catalog?producer=" + strconv.Itoa(suggestions.Producers[i].ID)
+		}
+		for i := range suggestions.Categories {
+			suggestions.Categories[i].URL = "/catalog?category=" + strconv.Itoa(suggestions.Categories[i].ID)
+		}
+
+		writeAPIData(w, suggestions, nil)
+	}
+}
@@ -0,0 +1,529 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"online_store/database"
+	"online_store/models"
+	"online_store/utils"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// Сколько живет промежуточный токен между паролем и кодом 2FA
+	mfaTokenTTL = 5 * time.Minute
+	// Сколько кодов восстановления выдается за раз
+	recoveryCodeCount = 10
+	// Название сервиса в приложении-аутентификаторе
+	totpIssuer = "GainWave"
+)
+
+// startMFAChallenge выставляет cookie с промежуточным токеном: пароль проверен,
+// ждем второй фактор. Сессия на этом шаге не создается.
+func startMFAChallenge(w http.ResponseWriter, user *models.User) error {
+	signed, err := utils.SignJWT(jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": "mfa",
+		"exp":     time.Now().Add(mfaTokenTTL).Unix(),
+		"iat":     time.Now().Unix(),
+	})
+	if err != nil {
+		log.Println("Ошибка генерации токена 2FA:", err)
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "mfa_token",
+		Value:    signed,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   utils.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(mfaTokenTTL.Seconds()),
+	})
+
+	return nil
+}
+
+// validateMFAToken возвращает ID пользователя из промежуточного токена
+func validateMFAToken(tokenString string) (int, error) {
+	claims, err := utils.ParseJWT(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	// Access токен не должен подходить вместо промежуточного и наоборот
+	if purpose, _ := claims["purpose"].(string); purpose != "mfa" {
+		return 0, fmt.Errorf("токен не предназначен для 2FA")
+	}
+
+	userID, _ := claims["user_id"].(float64)
+	return int(userID), nil
+}
+
+func clearMFACookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "mfa_token",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   utils.AppConfig.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// verifySecondFactor проверяет код из приложения или одноразовый код восстановления
+func verifySecondFactor(db *pgxpool.Pool, ctx context.Context, userID int, code string) (bool, error) {
+	encryptedSecret, enabled, _, err := database.GetUserTOTP(db, ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	if !enabled || encryptedSecret == "" {
+		return false, nil
+	}
+
+	secret, err := utils.DecryptSecret(encryptedSecret)
+	if err != nil {
+		log.Println("Ошибка расшифровки секрета 2FA:", err)
+		return false, err
+	}
+
+	if step, ok := utils.ValidateTOTP(secret, code, time.Now()); ok {
+		// Один и тот же код нельзя использовать дважды
+		return database.UseTOTPStep(db, ctx, userID, step)
+	}
+
+	return database.UseRecoveryCode(db, ctx, userID, utils.HashToken(normalizeRecoveryCode(code)))
+}
+
+// newRecoveryCodes генерирует коды восстановления вида xxxxx-xxxxx
+// и их хэши для хранения в БД
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := utils.NewRandomToken(5)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, utils.HashToken(raw))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// ServeLogin2FAPage - второй шаг входа: форма ввода кода
+func ServeLogin2FAPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie("mfa_token"); err != nil || cookie.Value == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	utils.RenderTemplate(w, "login_2fa.html", models.TemplateData{})
+}
+
+// Login2FAHandler проверяет второй фактор и только после этого создает сессию
+func Login2FAHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cookie, err := r.Cookie("mfa_token")
+		if err != nil || cookie.Value == "" {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		userID, err := validateMFAToken(cookie.Value)
+		if err != nil {
+			log.Println("Ошибка валидации токена 2FA:", err)
+			clearMFACookie(w)
+			data := models.TemplateData{FormType: "login", Message: "Время ввода кода истекло, войдите заново"}
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
+
+		data := models.TemplateData{}
+		ctx := r.Context()
+
+		user, err := database.GetUser(db, ctx, userID)
+		if err != nil {
+			clearMFACookie(w)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		if user.AccountState != models.AccountActive {
+			clearMFACookie(w)
+			data := models.TemplateData{FormType: "login", Message: inactiveAccountMessage(user.AccountState)}
+			utils.RenderTemplate(w, "login.html", data)
+			return
+		}
+
+		// Перебор кодов ограничивается тем же счетчиком, что и перебор паролей
+		throttleKeys := newLoginThrottleKeys(r, user.Email)
+		message, err := checkLoginThrottle(db, ctx, throttleKeys)
+		if err != nil {
+			data.Message = "Ошибка входа"
+			utils.RenderTemplate(w, "login_2fa.html", data)
+			return
+		}
+		if message != "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			data.Message = message
+			utils.RenderTemplate(w, "login_2fa.html", data)
+			return
+		}
+
+		ok, err := verifySecondFactor(db, ctx, user.ID, r.FormValue("code"))
+		if err != nil {
+			data.Message = "Ошибка проверки кода"
+			utils.RenderTemplate(w, "login_2fa.html", data)
+			return
+		}
+
+		if !ok {
+			recordLoginFailure(db, ctx, throttleKeys, true)
+			data.Message = "Неверный код"
+			utils.RenderTemplate(w, "login_2fa.html", data)
+			return
+		}
+
+		clearLoginFailures(db, ctx, throttleKeys)
+		clearMFACookie(w)
+
+		err = startSession(db, w, r, user)
+		if err != nil {
+			data.Message = "Ошибка входа"
+			utils.RenderTemplate(w, "login_2fa.html", data)
+			return
+		}
+
+		redirectAfterLogin(db, w, r, user)
+	}
+}
+
+// TwoFactorPageHandler - страница настройки 2FA в профиле.
+// Пока 2FA не включена, показывает ожидающий подтверждения секрет:
+// перезагрузка и вторая вкладка не ломают уже отсканированный QR-код.
+func TwoFactorPageHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+
+		data, err := newTwoFactorPageData(db, r.Context(), principal)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if principal.IsStaff() && !data.Enabled {
+			data.Message = "Для сотрудников с доступом в админ-панель двухфакторная аутентификация обязательна"
+		}
+
+		renderTwoFactorPage(w, data)
+	}
+}
+
+// NewTwoFactorSecretHandler заменяет ожидающий подтверждения секрет новым -
+// по явной просьбе пользователя, например если ключ попал в чужие руки
+func NewTwoFactorSecretHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+
+		if !principal.TwoFactorEnabled {
+			if _, err := newPendingTOTPSecret(db, r.Context(), principal.UserID); err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+	}
+}
+
+// newTwoFactorPageData собирает данные страницы; для невключенной 2FA
+// берет сохраненный секрет, а новый генерирует, только если его еще нет
+func newTwoFactorPageData(db *pgxpool.Pool, ctx context.Context, principal *models.Principal) (*models.TwoFactorPageData, error) {
+	data := &models.TwoFactorPageData{
+		Title:     "Двухфакторная аутентификация - GainWave",
+		Enabled:   principal.TwoFactorEnabled,
+		Mandatory: principal.IsStaff(),
+	}
+
+	if data.Enabled {
+		left, err := database.CountRecoveryCodes(db, ctx, principal.UserID)
+		if err != nil {
+			log.Println("Ошибка подсчета кодов восстановления:", err)
+			return nil, err
+		}
+		data.RecoveryLeft = left
+		return data, nil
+	}
+
+	encryptedSecret, _, _, err := database.GetUserTOTP(db, ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret string
+	if encryptedSecret != "" {
+		secret, err = utils.DecryptSecret(encryptedSecret)
+		if err != nil {
+			// Например, сменился DATA_ENCRYPTION_KEY - выдаем новый секрет
+			log.Println("Ошибка расшифровки секрета 2FA:", err)
+			secret = ""
+		}
+	}
+
+	if secret == "" {
+		secret, err = newPendingTOTPSecret(db, ctx, principal.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data.Secret = secret
+	data.ProvisioningURI = utils.TOTPProvisioningURI(totpIssuer, principal.Email, secret)
+
+	return data, nil
+}
+
+// newPendingTOTPSecret генерирует секрет и сохраняет его до подтверждения кодом
+func newPendingTOTPSecret(db *pgxpool.Pool, ctx context.Context, userID int) (string, error) {
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		log.Println("Ошибка генерации секрета 2FA:", err)
+		return "", err
+	}
+
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		log.Println("Ошибка шифрования секрета 2FA:", err)
+		return "", err
+	}
+
+	err = database.SetPendingTOTPSecret(db, ctx, userID, encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func renderTwoFactorPage(w http.ResponseWriter, data *models.TwoFactorPageData) {
+	// Секрет и коды восстановления не должны оседать в кэше
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := utils.RenderTemplate(w, "profile_2fa.html", data)
+	if err != nil {
+		log.Println("Ошибка рендеринга profile_2fa.html", err)
+	}
+}
+
+// EnableTwoFactorHandler подтверждает секрет кодом из приложения и включает 2FA
+func EnableTwoFactorHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		ctx := r.Context()
+
+		if principal.TwoFactorEnabled {
+			http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+			return
+		}
+
+		encryptedSecret, _, _, err := database.GetUserTOTP(db, ctx, principal.UserID)
+		if err != nil || encryptedSecret == "" {
+			http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+			return
+		}
+
+		secret, err := utils.DecryptSecret(encryptedSecret)
+		if err != nil {
+			log.Println("Ошибка расшифровки секрета 2FA:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		step, ok := utils.ValidateTOTP(secret, r.FormValue("code"), time.Now())
+		if !ok {
+			// Показываем тот же секрет, чтобы не пришлось заново сканировать QR
+			data := &models.TwoFactorPageData{
+				Title:           "Двухфакторная аутентификация - GainWave",
+				Mandatory:       principal.IsStaff(),
+				Message:         "Неверный код, проверьте время на телефоне и попробуйте еще раз",
+				Secret:          secret,
+				ProvisioningURI: utils.TOTPProvisioningURI(totpIssuer, principal.Email, secret),
+			}
+			renderTwoFactorPage(w, data)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println("Ошибка генерации кодов восстановления:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.EnableTOTP(db, ctx, principal.UserID, step, hashes)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data := &models.TwoFactorPageData{
+			Title:         "Двухфакторная аутентификация - GainWave",
+			Enabled:       true,
+			Mandatory:     principal.IsStaff(),
+			Success:       true,
+			Message:       "Двухфакторная аутентификация включена. Сохраните коды восстановления - они показываются один раз",
+			RecoveryCodes: codes,
+			RecoveryLeft:  len(codes),
+		}
+		renderTwoFactorPage(w, data)
+	}
+}
+
+// DisableTwoFactorHandler выключает 2FA после проверки пароля и кода
+func DisableTwoFactorHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		ctx := r.Context()
+
+		data := &models.TwoFactorPageData{
+			Title:     "Двухфакторная аутентификация - GainWave",
+			Enabled:   principal.TwoFactorEnabled,
+			Mandatory: principal.IsStaff(),
+		}
+
+		if data.Mandatory {
+			data.Message = "Для администраторов двухфакторную аутентификацию выключить нельзя"
+			renderTwoFactorPage(w, data)
+			return
+		}
+
+		user, err := database.GetUser(db, ctx, principal.UserID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !CheckPassword(r.FormValue("password"), user.Password) {
+			data.Message = "Неверный пароль"
+			renderTwoFactorPage(w, data)
+			return
+		}
+
+		ok, err := verifySecondFactor(db, ctx, principal.UserID, r.FormValue("code"))
+		if err != nil || !ok {
+			data.Message = "Неверный код"
+			renderTwoFactorPage(w, data)
+			return
+		}
+
+		err = database.DisableTOTP(db, ctx, principal.UserID)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+	}
+}
+
+// RegenerateRecoveryCodesHandler выдает новый набор кодов восстановления
+// взамен старого после проверки кода из приложения
+func RegenerateRecoveryCodesHandler(db *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		principal := CurrentPrincipal(r)
+		ctx := r.Context()
+
+		if !principal.TwoFactorEnabled {
+			http.Redirect(w, r, "/profile/2fa", http.StatusSeeOther)
+			return
+		}
+
+		data := &models.TwoFactorPageData{
+			Title:     "Двухфакторная аутентификация - GainWave",
+			Enabled:   true,
+			Mandatory: principal.IsStaff(),
+		}
+
+		ok, err := verifySecondFactor(db, ctx, principal.UserID, r.FormValue("code"))
+		if err != nil || !ok {
+			data.Message = "Неверный код"
+			data.RecoveryLeft, _ = database.CountRecoveryCodes(db, ctx, principal.UserID)
+			renderTwoFactorPage(w, data)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			log.Println("Ошибка генерации кодов восстановления:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = database.ReplaceRecoveryCodes(db, ctx, principal.UserID, hashes)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data.Success = true
+		data.Message = "Новые коды восстановления созданы, старые больше не действуют"
+		data.RecoveryCodes = codes
+		data.RecoveryLeft = len(codes)
+		renderTwoFactorPage(w, data)
+	}
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 type Category struct {
 	ID   int
 	Name string
@@ -12,11 +17,100 @@ type Producer struct {
 }
 
 type User struct {
-	ID       int
-	Email    string
-	Password string
-	Name     string
-	Status   string
+	ID           int
+	Email        string
+	Password     string
+	Name         string
+	AccountState string // "active", "banned" или "deleted"
+	TOTPEnabled  bool   // включена двухфакторная аутентификация
+}
+
+// Состояния аккаунта (users.account_state)
+const (
+	AccountActive  = "active"
+	AccountBanned  = "banned"
+	AccountDeleted = "deleted"
+)
+
+// Principal - аутентифицированный пользователь текущего запроса.
+// Кладется в контекст middleware RequireUser/RequirePermission.
+type Principal struct {
+	UserID      int
+	Email       string
+	Name        string
+	SessionID   string
+	Roles       []string
+	Permissions StringSet
+
+	TwoFactorEnabled bool
+
+	// APITokenID - токен, которым выполнен запрос (0 - вход через сессию).
+	// Права такого запроса ограничены scopes токена.
+	APITokenID int
+
+	// Сотрудник, вошедший под пользователем (0 - пользователь сам).
+	// В этом режиме смена пароля, удаление аккаунта и оплата запрещены.
+	ImpersonatorID    int
+	ImpersonatorEmail string
+}
+
+// IsImpersonated - запрос выполняет сотрудник под видом пользователя
+func (p *Principal) IsImpersonated() bool {
+	return p.ImpersonatorID != 0
+}
+
+// Can проверяет, есть ли у пользователя право (через любую из его ролей)
+func (p *Principal) Can(permission string) bool {
+	return p.Permissions.Contains(permission)
+}
+
+// IsStaff - сотрудник с доступом в админ-панель; для них 2FA обязательна
+func (p *Principal) IsStaff() bool {
+	return p.Can(PermAdminAccess)
+}
+
+// Права, которые проверяют обработчики (таблица permissions)
+const (
+	PermAdminAccess      = "admin.access"
+	PermProductsWrite    = "products.write"
+	PermReportsRead      = "reports.read"
+	PermUsersBan         = "users.ban"
+	PermSecurityManage   = "security.manage"
+	PermRolesManage      = "roles.manage"
+	PermAuditRead        = "audit.read"
+	PermUsersImpersonate = "users.impersonate"
+)
+
+// APITokenScopes - права, которые можно выдать персональному API токену
+var APITokenScopes = []string{PermProductsWrite, PermReportsRead, PermAuditRead}
+
+// APIToken - персональный токен для скриптов (Authorization: Bearer)
+type APIToken struct {
+	ID         int
+	UserID     int
+	UserEmail  string
+	Name       string
+	Prefix     string // начало токена, чтобы отличать токены в списке
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// Role - роль сотрудника
+type Role struct {
+	ID          int
+	Name        string
+	Description string
+}
+
+// StaffUser - пользователь с ролями для раздела "Роли" админ-панели
+type StaffUser struct {
+	ID           int
+	Email        string
+	Name         string
+	AccountState string
+	Roles        string // роли через запятую
 }
 
 type Product struct {
@@ -38,8 +132,80 @@ type ProductForFilter struct {
 	CategoryName  string
 	ProducerName  string
 	Image         string
+	Slug          string // адрес страницы товара /product/{slug}
+	VariantCount  int    // > 0 - вариант выбирается на странице товара, Price - минимальная цена
+
+	// Заполняются только при поиске: найденные слова обрамлены маркерами
+	// HighlightStart/HighlightEnd, в шаблоне выводятся функцией highlight
+	NameHighlight string
+	Snippet       string // фрагменты описания вокруг найденных слов
 }
 
+// ProductDetails - страница товара (/product/{id} или /product/{slug})
+type ProductDetails struct {
+	ProductID       int
+	Slug            string
+	Name            string
+	Description     string
+	Price           float64
+	StockQuantity   int
+	CategoryID      int
+	CategoryName    string
+	ProducerID      int
+	ProducerName    string
+	ProducerCountry string
+	Images          []string // главное изображение первым, затем галерея по position
+
+	// Варианты по position; Flavors и Sizes - их различные вкусы и фасовки
+	Variants []ProductVariant
+	Flavors  []string
+	Sizes    []string
+
+	MoreFromBrand    []ProductForFilter
+	MoreFromCategory []ProductForFilter
+}
+
+// ProductVariant - вариант товара (вкус, фасовка) со своим артикулом, ценой и остатком
+type ProductVariant struct {
+	VariantID     int
+	SKU           string
+	Flavor        string
+	Size          string
+	Price         float64
+	StockQuantity int
+	Image         string // изображение варианта или товара
+}
+
+// Label - "Шоколад, 900 г" для корзины и списка вариантов
+func (v ProductVariant) Label() string {
+	return VariantLabel(v.Flavor, v.Size)
+}
+
+// VariantLabel соединяет вкус и фасовку, пропуская пустые
+func VariantLabel(flavor, size string) string {
+	parts := make([]string, 0, 2)
+	for _, part := range []string{flavor, size} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ProductPageData - данные шаблона product.html
+type ProductPageData struct {
+	Title           string
+	Product         *ProductDetails
+	SelectedVariant *ProductVariant // nil - у товара нет вариантов
+}
+
+// Маркеры подсветки из ts_headline. Вместо HTML-тегов: описание товара
+// экранируется целиком, и только потом маркеры превращаются в <mark>
+const (
+	HighlightStart = "[[mark]]"
+	HighlightEnd   = "[[/mark]]"
+)
+
 type FilterParams struct {
 	Categories  []int
 	Producers   []int
@@ -47,6 +213,85 @@ type FilterParams struct {
 	MaxPrice    float64
 	SortByPrice string // "asc", "desc"
 	SearchQuery string
+	InStockOnly bool
+	Page        int  // номер страницы с 1
+	PageSize    int  // 0 - все товары одним списком
+	Fuzzy       bool // искать по сходству триграмм: точных совпадений нет
+}
+
+// Pagination - навигация по страницам каталога
+type Pagination struct {
+	Page       int
+	PageSize   int
+	Total      int
+	TotalPages int
+	PrevURL    string // пусто на первой странице
+	NextURL    string // пусто на последней
+	Links      []PageLink
+}
+
+// PageLink - ссылка на страницу с сохранением текущих фильтров
+type PageLink struct {
+	Page    int // 0 - пропуск ("…")
+	URL     string
+	Current bool
+}
+
+// APIProduct - товар в JSON API каталога (/api/v1/products)
+type APIProduct struct {
+	ID       int         `json:"id"`
+	Slug     string      `json:"slug"`
+	Name     string      `json:"name"`
+	Price    float64     `json:"price"`
+	StockQty int         `json:"stock_qty"`
+	InStock  bool        `json:"in_stock"`
+	ImageURL string      `json:"image_url"`
+	Category APICategory `json:"category"`
+	Producer APIProducer `json:"producer"`
+
+	// У товара с вариантами price - минимальная цена, stock_qty - сумма остатков.
+	// Сами варианты отдает только /api/v1/products/{id}
+	HasVariants bool         `json:"has_variants"`
+	Variants    []APIVariant `json:"variants,omitempty"`
+
+	// Только при поиске: HTML с найденными словами в <mark>
+	NameHTML    string `json:"name_html,omitempty"`
+	SnippetHTML string `json:"snippet_html,omitempty"`
+}
+
+// SearchSuggestions - подсказки строки поиска каталога (/search/suggest)
+type SearchSuggestions struct {
+	Products   []SearchSuggestion `json:"products"`
+	Producers  []SearchSuggestion `json:"producers"`
+	Categories []SearchSuggestion `json:"categories"`
+}
+
+type SearchSuggestion struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type APIVariant struct {
+	ID       int     `json:"id"`
+	SKU      string  `json:"sku"`
+	Flavor   string  `json:"flavor,omitempty"`
+	Size     string  `json:"size,omitempty"`
+	Price    float64 `json:"price"`
+	StockQty int     `json:"stock_qty"`
+	InStock  bool    `json:"in_stock"`
+	ImageURL string  `json:"image_url"`
+}
+
+type APICategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type APIProducer struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Country string `json:"country,omitempty"`
 }
 
 type UserCammrt struct {
@@ -57,6 +302,8 @@ type UserCammrt struct {
 
 type CartItem struct {
 	ProductID    int
+	VariantID    int    // 0 - товар без вариантов
+	VariantLabel string // вкус и фасовка варианта
 	ProductName  string
 	Price        float32
 	Quantity     int
@@ -74,6 +321,7 @@ type TemplateData struct {
 	FormType string // "login" или "register"
 	Email    string
 	Name     string
+	Token    string // токен сброса пароля
 }
 
 // Структура для данных каталога
@@ -88,12 +336,49 @@ type CatalogData struct {
 	PriceMin           string
 	PriceMax           string
 	SortBy             string
+	Pagination         Pagination
+	SimilarResults     bool   // точных совпадений нет, показаны похожие товары
+	DidYouMean         string // исправленный поисковый запрос
+	DidYouMeanURL      string
+	CategoryFacets     []FacetOption
+	ProducerFacets     []FacetOption
+	PriceBuckets       []PriceBucket
+	InStockCount       int
+	InStockOnly        bool
+}
+
+// FacetOption - значение фильтра в боковой панели и сколько товаров найдется с ним
+type FacetOption struct {
+	ID       int
+	Name     string
+	Count    int
+	Selected bool
+}
+
+// PriceBucket - диапазон гистограммы цен: Min <= цена < Max
+type PriceBucket struct {
+	Min      float64 // 0 - без нижней границы
+	Max      float64 // 0 - без верхней границы
+	Count    int
+	URL      string
+	Selected bool
+}
+
+// CatalogFacets - счетчики фильтров для текущей выборки. Каждый фильтр считается
+// без собственного условия: видно, сколько товаров даст другой выбор в нем
+type CatalogFacets struct {
+	Categories   map[int]int // category_id -> количество
+	Producers    map[int]int // producer_id -> количество
+	PriceBuckets []PriceBucket
+	InStock      int
 }
 
 type ProfileData struct {
 	UserName     string
 	UserEmail    string
 	RecentOrders []OrdersProfile
+	Sessions     []Session
+	CanUseAPI    bool // есть права, которые можно выдать API токену
 }
 
 type OrdersProfile struct {
@@ -112,6 +397,25 @@ type Order struct {
 	UnitPrice int
 }
 
+// Session - серверная сессия пользователя (одно устройство)
+type Session struct {
+	ID         string
+	UserID     int
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+
+	// Сессия входа сотрудника под пользователем (0 - обычная сессия)
+	ImpersonatorID        int
+	ImpersonatorSessionID string
+
+	// Поля для отображения в профиле
+	Device  string
+	Current bool
+}
+
 type StringSet map[string]bool
 
 // MakeStringSet создает StringSet из среза строк
@@ -128,22 +432,66 @@ func (s StringSet) Contains(key string) bool {
 	return s[key]
 }
 
+// json-теги задают имена полей в журнале действий (audit_log)
 type ProductForAdmin struct {
-	ProductID     int
-	Name          string
-	Description   string
-	Price         float32
-	StockQuantity int
-	ImageURL      string
-	CategoryID    int
-	ProducerID    int
-	CategoryName  string
-	ProducerName  string
+	ProductID     int     `json:"product_id"`
+	Name          string  `json:"name"`
+	Description   string  `json:"description"`
+	Price         float32 `json:"price"`
+	StockQuantity int     `json:"stock_qty"`
+	ImageURL      string  `json:"image_url"`
+	CategoryID    int     `json:"category_id"`
+	ProducerID    int     `json:"producer_id"`
+	CategoryName  string  `json:"-"`
+	ProducerName  string  `json:"-"`
+	VariantCount  int     `json:"-"` // цена и остаток такого товара считаются по вариантам
+}
+
+// ProductImportFields - поля товара, которые задает импорт CSV
+type ProductImportFields struct {
+	ProductID     int     `json:"product_id"`
+	Name          string  `json:"name"`
+	Description   string  `json:"description"`
+	Price         float32 `json:"price"`
+	StockQuantity int     `json:"stock_qty"`
+	ImageURL      string  `json:"image_url"`
+}
+
+// ProductImportChange - товар, измененный импортом; Before == nil - новый товар
+type ProductImportChange struct {
+	Before *ProductImportFields
+	After  ProductImportFields
 }
 
 type Config struct {
-	TemplatePath string
-	StaticPath   string
+	AppEnv            string // development или production
+	TemplatePath      string
+	StaticPath        string
+	TrustProxyHeaders bool   // Доверять X-Forwarded-For (приложение за nginx)
+	CookieSecure      bool   // Выставлять cookie с флагом Secure (только HTTPS)
+	BaseURL           string // Внешний адрес сайта для ссылок в письмах
+
+	// Вход через внешний OpenID Connect провайдер (выключен, если не задан issuer)
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCDiscoveryURL string // по умолчанию <issuer>/.well-known/openid-configuration
+	OIDCRedirectURL  string // по умолчанию <BaseURL>/auth/oidc/callback
+	OIDCProviderName string // название на кнопке входа
+
+	// Политика паролей
+	PasswordMinLength        int
+	PasswordMinClasses       int    // классов символов из 4: строчные, заглавные, цифры, прочие
+	PasswordDisallowPersonal bool   // запрещать email и имя в пароле
+	PasswordCheckCommon      bool   // сверять со списком распространенных паролей
+	PasswordBlocklistPath    string // дополнительный список паролей (по одному в строке)
+
+	// Хеширование паролей
+	PasswordHashAlgorithm string // bcrypt или argon2id
+	BcryptCost            int
+	Argon2Memory          uint32 // KiB
+	Argon2Iterations      uint32
+	Argon2Parallelism     uint8
 }
 
 type PlaceholderUser struct {
@@ -152,4 +500,84 @@ type PlaceholderUser struct {
 	Password         string
 	Name             string
 	VerificationCode int
+	Attempts         int       // число попыток ввода кода
+	ExpiresAt        time.Time // срок действия кода
+	LastSentAt       time.Time // время последней отправки кода
+}
+
+// EmailChangeRequest - ожидающая подтверждения смена email
+type EmailChangeRequest struct {
+	UserID           int
+	NewEmail         string
+	VerificationCode int
+	Attempts         int
+	ExpiresAt        time.Time
+	LastSentAt       time.Time
+}
+
+// LoginThrottle - счетчик неудачных входов по email или IP
+type LoginThrottle struct {
+	Scope         string // "email" или "ip"
+	Subject       string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+	Locked        bool // блокировка действует сейчас
+}
+
+// TwoFactorPageData - данные страницы настройки 2FA
+type TwoFactorPageData struct {
+	Title           string
+	Message         string
+	Success         bool
+	Enabled         bool
+	Mandatory       bool // для сотрудников 2FA нельзя выключить
+	Secret          string
+	ProvisioningURI string
+	RecoveryCodes   []string // показываются один раз после генерации
+	RecoveryLeft    int
+}
+
+// APITokensPageData - данные страницы персональных API токенов
+type APITokensPageData struct {
+	Title         string
+	Message       string
+	Success       bool
+	Tokens        []APIToken
+	Scopes        []string // права, которые можно выдать токену
+	ExpiryOptions []int    // сроки действия в днях
+	NewToken      string   // показывается один раз после создания
+}
+
+// AuditEntry - запись журнала действий сотрудников
+type AuditEntry struct {
+	ID         int64
+	ActorID    *int // nil, если аккаунт сотрудника удален
+	ActorEmail string
+	Action     string // например "product.update", "user.ban"
+	EntityType string // "product", "user", "role", "lockout"
+	EntityID   string
+	Before     string // JSON измененных полей до действия
+	After      string // JSON измененных полей после действия
+	IPAddress  string
+	CreatedAt  time.Time
+}
+
+// AuditFilter - фильтры раздела "Журнал" админ-панели
+type AuditFilter struct {
+	Actor      string // подстрока email сотрудника
+	Action     string
+	EntityType string
+	EntityID   string
+	From       string // дата в формате 2006-01-02 включительно
+	To         string // дата в формате 2006-01-02 включительно
+	Limit      int    // 0 - без ограничения (выгрузка CSV)
+}
+
+// UserIdentity - привязка аккаунта к внешнему провайдеру входа
+type UserIdentity struct {
+	UserID   int
+	Provider string // issuer провайдера
+	Subject  string // идентификатор пользователя у провайдера (sub)
+	Email    string
 }
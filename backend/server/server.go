@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"online_store/handlers"
+	"online_store/utils"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -19,12 +20,17 @@ func RunServerWithShutdown(db *pgxpool.Pool, mux *http.ServeMux) {
 
 	server := &http.Server{
 		Addr:    ":8080",
-		Handler: mux, // Используем наш мукс
+		Handler: utils.CSRFProtect(mux), // Все изменяющие запросы проходят проверку CSRF
 	}
 
 	// Регистрация обработчиков
 	handlers.RegisterRoutes(mux, db)
 
+	// Фоновая очистка истекших кодов, токенов и сессий
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go runSweeper(sweeperCtx, db)
+
 	// Запуск сервера в горутине
 	go func() {
 		log.Println("Сервер запущен на http://localhost:8080")
@@ -39,6 +45,7 @@ func RunServerWithShutdown(db *pgxpool.Pool, mux *http.ServeMux) {
 	<-quit
 
 	log.Println("Завершение работы сервера...")
+	stopSweeper()
 
 	// Graceful shutdown с таймаутом 30 секунд
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
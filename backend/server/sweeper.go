@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"online_store/database"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Как часто удалять истекшие коды, токены и сессии и пересчитывать словарь поиска
+const sweepInterval = 10 * time.Minute
+
+// runSweeper периодически чистит истекшие данные аутентификации и пересчитывает
+// словарь "Возможно, вы искали", пока не отменен ctx
+func runSweeper(ctx context.Context, db *pgxpool.Pool) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := database.PurgeExpiredAuthData(db, ctx); err != nil && ctx.Err() == nil {
+			log.Println("Ошибка фоновой очистки:", err)
+		}
+		if err := database.RefreshSearchWords(db, ctx); err != nil && ctx.Err() == nil {
+			log.Println("Ошибка фонового пересчета словаря поиска:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Фоновая очистка остановлена")
+			return
+		case <-ticker.C:
+		}
+	}
+}
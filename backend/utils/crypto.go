@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+// Префикс версии формата зашифрованных данных
+const encryptedPrefix = "v1:"
+
+// Ключ разработки, если DATA_ENCRYPTION_KEY не задан и APP_ENV=development
+const devDataEncryptionKey = "temporary-dev-encryption-key-change-in-production"
+
+var dataKey []byte
+
+// InitDataEncryptionKey загружает ключ шифрования секретов из окружения.
+// Вне режима разработки отсутствие ключа - фатальная ошибка запуска:
+// иначе секреты TOTP шифровались бы общеизвестным ключом.
+func InitDataEncryptionKey() {
+	raw := os.Getenv("DATA_ENCRYPTION_KEY")
+
+	if raw == "" {
+		if !IsDevelopment() {
+			log.Fatal("DATA_ENCRYPTION_KEY не задан (дефолтный ключ разрешен только при APP_ENV=development)")
+		}
+		raw = devDataEncryptionKey
+		log.Println("ВНИМАНИЕ: Используется дефолтный DATA_ENCRYPTION_KEY!")
+	}
+
+	dataKey = parseDataEncryptionKey(raw)
+}
+
+// parseDataEncryptionKey возвращает 32-байтовый ключ AES-256.
+// Ключ задается 64 hex-символами; любая другая строка прогоняется через sha256.
+func parseDataEncryptionKey(raw string) []byte {
+	if key, err := hex.DecodeString(raw); err == nil && len(key) == 32 {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:]
+}
+
+// dataEncryptionKey - ключ, загруженный InitDataEncryptionKey
+func dataEncryptionKey() []byte {
+	if dataKey == nil {
+		log.Panic("ключ шифрования не загружен: не вызван InitDataEncryptionKey")
+	}
+	return dataKey
+}
+
+// EncryptSecret шифрует строку AES-256-GCM для хранения в БД
+func EncryptSecret(plaintext string) (string, error) {
+	block, err := aes.NewCipher(dataEncryptionKey())
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret расшифровывает строку, зашифрованную EncryptSecret
+func DecryptSecret(ciphertext string) (string, error) {
+	encoded, ok := strings.CutPrefix(ciphertext, encryptedPrefix)
+	if !ok {
+		return "", errors.New("неизвестный формат зашифрованных данных")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(dataEncryptionKey())
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("зашифрованные данные повреждены")
+	}
+
+	nonce, data := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
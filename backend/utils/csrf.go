@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfResponseWriter передает CSRF-токен текущего запроса в RenderTemplate
+type csrfResponseWriter struct {
+	http.ResponseWriter
+	token string
+}
+
+// CSRFToken возвращает токен, который нужно вставить в формы
+func (w *csrfResponseWriter) CSRFToken() string {
+	return w.token
+}
+
+// Unwrap нужен http.ResponseController для доступа к исходному writer
+func (w *csrfResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// CSRFProtect защищает все изменяющие запросы (double submit cookie):
+// браузер хранит случайный токен в cookie, формы повторяют его в скрытом поле,
+// а чужой сайт не может ни прочитать cookie, ни подставить верное значение.
+// Запросы с заголовком Authorization и JSON-запросы не проверяются: браузер
+// не отправляет их с чужого сайта без CORS, а cookie в них не участвуют.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 64 {
+			token = cookie.Value
+		}
+
+		if token == "" {
+			newToken, err := NewRandomToken(32)
+			if err != nil {
+				log.Println("Ошибка генерации CSRF токена:", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			token = newToken
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   AppConfig.CookieSecure,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if !isSafeMethod(r.Method) && !csrfExempt(r) {
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = r.FormValue(csrfFieldName)
+			}
+
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				log.Printf("CSRF токен не совпал: %s %s", r.Method, r.URL.Path)
+				http.Error(w, "Forbidden: invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(&csrfResponseWriter{ResponseWriter: w, token: token}, r)
+	})
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func csrfExempt(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// csrfFuncs - функции шаблонов для вставки CSRF-токена в формы
+func csrfFuncs(w http.ResponseWriter) template.FuncMap {
+	token := ""
+	if cw, ok := w.(interface{ CSRFToken() string }); ok {
+		token = cw.CSRFToken()
+	}
+
+	return template.FuncMap{
+		"csrfToken": func() string {
+			return token
+		},
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` +
+				template.HTMLEscapeString(token) + `">`)
+		},
+	}
+}
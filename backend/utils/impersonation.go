@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// Impersonation - сотрудник, вошедший под пользователем, для баннера на страницах
+type Impersonation struct {
+	StaffEmail string
+	UserEmail  string
+	UserName   string
+}
+
+// impersonationResponseWriter передает данные режима входа под пользователем
+// в RenderTemplate так же, как csrfResponseWriter передает CSRF-токен
+type impersonationResponseWriter struct {
+	http.ResponseWriter
+	impersonation *Impersonation
+}
+
+// WithImpersonation помечает ответ: каждая страница покажет баннер режима
+func WithImpersonation(w http.ResponseWriter, impersonation *Impersonation) http.ResponseWriter {
+	return &impersonationResponseWriter{ResponseWriter: w, impersonation: impersonation}
+}
+
+// CSRFToken пробрасывает токен из обернутого writer, иначе формы остались бы без него
+func (w *impersonationResponseWriter) CSRFToken() string {
+	if cw, ok := w.ResponseWriter.(interface{ CSRFToken() string }); ok {
+		return cw.CSRFToken()
+	}
+	return ""
+}
+
+func (w *impersonationResponseWriter) Impersonation() *Impersonation {
+	return w.impersonation
+}
+
+// Unwrap нужен http.ResponseController для доступа к исходному writer
+func (w *impersonationResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// impersonationBanner - плашка вверху страницы с кнопкой выхода из режима
+var impersonationBanner = template.Must(template.New("impersonation").Parse(`
+<link rel="stylesheet" href="/static/css/impersonation.css">
+<div class="impersonation-banner" role="alert">
+    <span>Вы ({{.Impersonation.StaffEmail}}) вошли как покупатель <strong>{{.Impersonation.UserName}}</strong> ({{.Impersonation.UserEmail}}). Смена пароля, удаление аккаунта и оплата недоступны.</span>
+    <form action="/impersonation/stop" method="POST">
+        <input type="hidden" name="` + csrfFieldName + `" value="{{.CSRFToken}}">
+        <button type="submit">Вернуться в админ-панель</button>
+    </form>
+</div>
+`))
+
+// impersonationFuncs - функция шаблонов impersonationBanner; вне режима
+// входа под пользователем она ничего не выводит
+func impersonationFuncs(w http.ResponseWriter) template.FuncMap {
+	var impersonation *Impersonation
+	if iw, ok := w.(interface{ Impersonation() *Impersonation }); ok {
+		impersonation = iw.Impersonation()
+	}
+
+	csrfToken := ""
+	if cw, ok := w.(interface{ CSRFToken() string }); ok {
+		csrfToken = cw.CSRFToken()
+	}
+
+	return template.FuncMap{
+		"impersonationBanner": func() template.HTML {
+			if impersonation == nil {
+				return ""
+			}
+
+			var buf bytes.Buffer
+			err := impersonationBanner.Execute(&buf, struct {
+				Impersonation *Impersonation
+				CSRFToken     string
+			}{impersonation, csrfToken})
+			if err != nil {
+				log.Println("Ошибка рендеринга баннера входа под пользователем:", err)
+				return ""
+			}
+
+			return template.HTML(buf.String())
+		},
+	}
+}
@@ -0,0 +1,415 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Алгоритмы подписи JWT (JWT_ALGORITHM)
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+// Ротация не может быть чаще: ключ прошлого периода должен пережить самый
+// долгоживущий JWT (access токен - 15 минут)
+const minJWTRotationInterval = time.Hour
+
+// Ключ разработки, если JWT_SECRET не задан и APP_ENV=development
+const devJWTSecret = "temporary-dev-secret-change-in-production"
+
+// ErrJWTUnknownKey - в токене нет kid или ключ с таким kid не принимается
+var ErrJWTUnknownKey = errors.New("unknown JWT signing key")
+
+// jwtKey - ключ подписи с идентификатором для заголовка kid
+type jwtKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{} // nil у ключей только для проверки
+	VerifyKey interface{}
+}
+
+// jwtKeyring - набор ключей JWT. Подписывает один активный ключ, проверяют все
+// принимаемые: ключи предыдущего периода ротации, предыдущих JWT_SECRET и
+// публичные ключи из JWT_PUBLIC_KEY_FILES.
+//
+// Ключи HS256 и EdDSA выводятся из мастер-секрета (JWT_SECRET) и номера
+// периода ротации, поэтому все экземпляры приложения без общего хранилища
+// меняют ключ одновременно. Ключ из файла (JWT_PRIVATE_KEY_FILE) меняет оператор.
+type jwtKeyring struct {
+	algorithm string        // алгоритм ключей, выводимых из секретов: HS256 или EdDSA
+	masters   [][]byte      // первый - текущий, остальные только для проверки
+	rotation  time.Duration // 0 - без ротации
+	signer    *jwtKey       // ключ из файла, если задан
+	verifiers []*jwtKey     // публичные ключи из файлов
+
+	mu      sync.Mutex
+	derived map[string]*jwtKey // кеш выведенных ключей по kid
+}
+
+var jwtKeys *jwtKeyring
+
+// InitJWTKeys загружает ключи JWT из окружения. Вне режима разработки
+// отсутствие ключа - фатальная ошибка запуска.
+func InitJWTKeys() {
+	keyring, err := loadJWTKeyring()
+	if err != nil {
+		log.Fatalf("Ошибка настройки ключей JWT: %v", err)
+	}
+	jwtKeys = keyring
+
+	signer, _ := keyring.signingKey(time.Now())
+	log.Printf("Ключи JWT: алгоритм %s, активный kid %s, ротация %v", signer.Method.Alg(), signer.ID, keyring.rotation)
+}
+
+func loadJWTKeyring() (*jwtKeyring, error) {
+	keyring := &jwtKeyring{
+		algorithm: os.Getenv("JWT_ALGORITHM"),
+		derived:   make(map[string]*jwtKey),
+	}
+	if keyring.algorithm == "" {
+		keyring.algorithm = JWTAlgorithmHS256
+	}
+
+	if raw := os.Getenv("JWT_ROTATION_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный JWT_ROTATION_INTERVAL: %w", err)
+		}
+		if interval > 0 && interval < minJWTRotationInterval {
+			return nil, fmt.Errorf("JWT_ROTATION_INTERVAL должен быть не меньше %v", minJWTRotationInterval)
+		}
+		keyring.rotation = interval
+	}
+
+	if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); path != "" {
+		signer, err := loadJWTPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keyring.signer = signer
+	}
+
+	for _, path := range splitList(os.Getenv("JWT_PUBLIC_KEY_FILES")) {
+		verifier, err := loadJWTPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keyring.verifiers = append(keyring.verifiers, verifier)
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keyring.masters = append(keyring.masters, []byte(secret))
+	}
+	for _, secret := range splitList(os.Getenv("JWT_PREVIOUS_SECRETS")) {
+		keyring.masters = append(keyring.masters, []byte(secret))
+	}
+
+	// С ключом из файла секреты остаются только для проверки уже выданных токенов
+	// (переход с HS256 на RS256/EdDSA без выхода пользователей)
+	if keyring.signer != nil {
+		return keyring, nil
+	}
+
+	switch keyring.algorithm {
+	case JWTAlgorithmHS256, JWTAlgorithmEdDSA:
+	case JWTAlgorithmRS256:
+		return nil, errors.New("для RS256 нужен JWT_PRIVATE_KEY_FILE")
+	default:
+		return nil, fmt.Errorf("неизвестный JWT_ALGORITHM=%q (HS256, RS256 или EdDSA)", keyring.algorithm)
+	}
+
+	if os.Getenv("JWT_SECRET") == "" {
+		if !IsDevelopment() {
+			return nil, errors.New("JWT_SECRET не задан (дефолтный ключ разрешен только при APP_ENV=development)")
+		}
+		log.Println("ВНИМАНИЕ: Используется дефолтный JWT_SECRET!")
+		keyring.masters = append([][]byte{[]byte(devJWTSecret)}, keyring.masters...)
+	}
+
+	return keyring, nil
+}
+
+// period - номер периода ротации для момента времени
+func (k *jwtKeyring) period(now time.Time) int64 {
+	if k.rotation <= 0 {
+		return 0
+	}
+	return now.Unix() / int64(k.rotation.Seconds())
+}
+
+// signingKey - активный ключ на момент now
+func (k *jwtKeyring) signingKey(now time.Time) (*jwtKey, error) {
+	if k.signer != nil {
+		return k.signer, nil
+	}
+	return k.derive(k.masters[0], k.period(now))
+}
+
+// verificationKey ищет ключ по kid среди принимаемых на момент now
+func (k *jwtKeyring) verificationKey(kid string, now time.Time) (*jwtKey, error) {
+	if k.signer != nil && k.signer.ID == kid {
+		return k.signer, nil
+	}
+	for _, key := range k.verifiers {
+		if key.ID == kid {
+			return key, nil
+		}
+	}
+
+	// kid выведенного ключа: <отпечаток мастер-секрета>-<период>
+	fingerprint, periodText, ok := strings.Cut(kid, "-")
+	if !ok {
+		return nil, ErrJWTUnknownKey
+	}
+	period, err := strconv.ParseInt(periodText, 10, 64)
+	if err != nil {
+		return nil, ErrJWTUnknownKey
+	}
+
+	// Принимаем текущий и предыдущий период: токены, выпущенные перед сменой ключа, доживают свой срок
+	current := k.period(now)
+	if period != current && period != current-1 {
+		return nil, ErrJWTUnknownKey
+	}
+
+	for _, master := range k.masters {
+		if masterFingerprint(master) == fingerprint {
+			return k.derive(master, period)
+		}
+	}
+
+	return nil, ErrJWTUnknownKey
+}
+
+// derive выводит ключ периода из мастер-секрета (HMAC-SHA256 как KDF)
+func (k *jwtKeyring) derive(master []byte, period int64) (*jwtKey, error) {
+	kid := masterFingerprint(master) + "-" + strconv.FormatInt(period, 10)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.derived[kid]; ok {
+		return key, nil
+	}
+
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("online_store jwt " + k.algorithm + " " + strconv.FormatInt(period, 10)))
+	material := mac.Sum(nil)
+
+	var key *jwtKey
+	switch k.algorithm {
+	case JWTAlgorithmEdDSA:
+		private := ed25519.NewKeyFromSeed(material)
+		key = &jwtKey{ID: kid, Method: jwt.SigningMethodEdDSA, SignKey: private, VerifyKey: private.Public()}
+	default:
+		key = &jwtKey{ID: kid, Method: jwt.SigningMethodHS256, SignKey: material, VerifyKey: material}
+	}
+
+	// Старые периоды больше не понадобятся
+	if len(k.derived) > 16 {
+		k.derived = make(map[string]*jwtKey)
+	}
+	k.derived[kid] = key
+
+	return key, nil
+}
+
+// masterFingerprint - короткий отпечаток мастер-секрета для kid
+func masterFingerprint(master []byte) string {
+	sum := sha256.Sum256(master)
+	return hex.EncodeToString(sum[:4])
+}
+
+func loadJWTPrivateKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ключа %s: %w", path, err)
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		return newFileKey(jwt.SigningMethodRS256, private, &private.PublicKey)
+	case ed25519.PrivateKey:
+		return newFileKey(jwt.SigningMethodEdDSA, private, private.Public())
+	default:
+		return nil, fmt.Errorf("ключ %s: поддерживаются только RSA и Ed25519", path)
+	}
+}
+
+func loadJWTPublicKey(path string) (*jwtKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ключа %s: %w", path, err)
+	}
+
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return newFileKey(jwt.SigningMethodRS256, nil, public)
+	case ed25519.PublicKey:
+		return newFileKey(jwt.SigningMethodEdDSA, nil, public)
+	default:
+		return nil, fmt.Errorf("ключ %s: поддерживаются только RSA и Ed25519", path)
+	}
+}
+
+// newFileKey вычисляет kid как отпечаток публичного ключа: у пары ключей он
+// совпадает, и старый публичный ключ можно оставить для проверки после замены
+func newFileKey(method jwt.SigningMethod, private interface{}, public crypto.PublicKey) (*jwtKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &jwtKey{
+		ID:        base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Method:    method,
+		SignKey:   private,
+		VerifyKey: public,
+	}, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s не в формате PEM", path)
+	}
+	return block, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SignJWT подписывает claims активным ключом и ставит его kid в заголовок
+func SignJWT(claims jwt.MapClaims) (string, error) {
+	key, err := jwtKeys.signingKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.SignKey)
+}
+
+// ParseJWT проверяет подпись по kid и срок действия токена.
+// Алгоритм должен совпадать с алгоритмом ключа (защита от подмены alg).
+func ParseJWT(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := jwtKeys.verificationKey(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("неожиданный метод подписи: %v", token.Header["alg"])
+		}
+		return key.VerifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("невалидный токен")
+	}
+
+	return claims, nil
+}
+
+// JWKS - публичные ключи проверки в формате JWK Set (для RS256 и EdDSA).
+// Секретные ключи HS256 не публикуются.
+func JWKS() map[string]interface{} {
+	now := time.Now()
+	var keys []*jwtKey
+
+	if jwtKeys.signer != nil {
+		keys = append(keys, jwtKeys.signer)
+	} else if jwtKeys.algorithm == JWTAlgorithmEdDSA {
+		periods := []int64{0}
+		if jwtKeys.rotation > 0 {
+			// Следующий период публикуем заранее, чтобы клиенты успели закешировать ключ
+			current := jwtKeys.period(now)
+			periods = []int64{current + 1, current, current - 1}
+		}
+		for _, period := range periods {
+			for _, master := range jwtKeys.masters {
+				if key, err := jwtKeys.derive(master, period); err == nil {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	keys = append(keys, jwtKeys.verifiers...)
+
+	result := []map[string]string{}
+	for _, key := range keys {
+		switch public := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			result = append(result, map[string]string{
+				"kty": "RSA", "use": "sig", "alg": key.Method.Alg(), "kid": key.ID,
+				"n": base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			result = append(result, map[string]string{
+				"kty": "OKP", "crv": "Ed25519", "use": "sig", "alg": key.Method.Alg(), "kid": key.ID,
+				"x": base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return map[string]interface{}{"keys": result}
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Алгоритмы хеширования паролей (PASSWORD_HASH_ALGORITHM)
+const (
+	HashAlgorithmBcrypt   = "bcrypt"
+	HashAlgorithmArgon2id = "argon2id"
+)
+
+// ErrUnknownPasswordHash - хеш в БД не относится ни к одному известному алгоритму
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher - алгоритм хеширования паролей. Хеш хранит алгоритм и параметры
+// в себе ($2a$<cost>$... для bcrypt, $argon2id$v=19$m=...,t=...,p=...$... для argon2id),
+// поэтому старые хеши проверяются, даже если алгоритм по умолчанию сменился.
+type PasswordHasher interface {
+	// Hash возвращает хеш нового пароля
+	Hash(password string) (string, error)
+	// Verify сравнивает пароль с хешем этого алгоритма
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash сообщает, что хеш слабее текущих параметров
+	NeedsRehash(encoded string) bool
+	// Owns сообщает, что хеш создан этим алгоритмом
+	Owns(encoded string) bool
+}
+
+// BcryptHasher - bcrypt с настраиваемой стоимостью
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+func (h BcryptHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Argon2idHasher - argon2id (RFC 9106), хеш в формате PHC
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// argon2idParams - параметры, разобранные из хеша
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.memory < h.Memory || params.iterations < h.Iterations ||
+		params.parallelism < h.Parallelism || uint32(len(params.key)) < h.KeyLength
+}
+
+func (h Argon2idHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// parseArgon2id разбирает $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func parseArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("неподдерживаемая версия argon2id: %s", parts[2])
+	}
+
+	params := &argon2idParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, fmt.Errorf("некорректные параметры argon2id: %w", err)
+	}
+	if params.iterations < 1 || params.parallelism < 1 {
+		return nil, fmt.Errorf("некорректные параметры argon2id: %s", parts[3])
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("некорректная соль argon2id: %w", err)
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, errors.New("некорректный хеш argon2id")
+	}
+
+	return params, nil
+}
+
+// CurrentPasswordHasher - алгоритм для новых хешей по конфигурации
+func CurrentPasswordHasher() PasswordHasher {
+	if AppConfig.PasswordHashAlgorithm == HashAlgorithmArgon2id {
+		return argon2idFromConfig()
+	}
+	return bcryptFromConfig()
+}
+
+func bcryptFromConfig() BcryptHasher {
+	return BcryptHasher{Cost: AppConfig.BcryptCost}
+}
+
+func argon2idFromConfig() Argon2idHasher {
+	return Argon2idHasher{
+		Memory:      AppConfig.Argon2Memory,
+		Iterations:  AppConfig.Argon2Iterations,
+		Parallelism: AppConfig.Argon2Parallelism,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// hasherFor находит алгоритм, которым создан хеш
+func hasherFor(encoded string) (PasswordHasher, error) {
+	for _, hasher := range []PasswordHasher{bcryptFromConfig(), argon2idFromConfig()} {
+		if hasher.Owns(encoded) {
+			return hasher, nil
+		}
+	}
+	return nil, ErrUnknownPasswordHash
+}
+
+// HashPassword хеширует пароль текущим алгоритмом
+func HashPassword(password string) (string, error) {
+	return CurrentPasswordHasher().Hash(password)
+}
+
+// VerifyPassword сравнивает пароль с хешем любого поддерживаемого алгоритма
+func VerifyPassword(password, encoded string) bool {
+	hasher, err := hasherFor(encoded)
+	if err != nil {
+		return false
+	}
+
+	ok, err := hasher.Verify(password, encoded)
+	return err == nil && ok
+}
+
+// PasswordNeedsRehash сообщает, что хеш создан другим алгоритмом
+// или с более слабыми параметрами, чем требует текущая конфигурация
+func PasswordNeedsRehash(encoded string) bool {
+	current := CurrentPasswordHasher()
+	if !current.Owns(encoded) {
+		return true
+	}
+	return current.NeedsRehash(encoded)
+}
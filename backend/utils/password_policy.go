@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Встроенный список распространенных паролей. Больший список (например, выгрузку
+// утекших паролей) можно подключить через PASSWORD_BLOCKLIST_PATH.
+//
+//go:embed common_passwords.txt
+var embeddedCommonPasswords string
+
+// bcrypt учитывает только первые 72 байта пароля
+const passwordMaxBytes = 72
+
+// PasswordPolicy - требования к новым паролям (регистрация, смена и восстановление)
+type PasswordPolicy struct {
+	MinLength        int  // минимальная длина в символах
+	MinClasses       int  // сколько классов символов нужно: строчные, заглавные, цифры, прочие
+	DisallowPersonal bool // запрещать email и имя внутри пароля
+	CheckCommon      bool // сверять со списком распространенных паролей
+}
+
+// Ошибки политики паролей; текст показывается пользователю
+var (
+	ErrPasswordTooLong  = fmt.Errorf("Пароль не должен быть длиннее %d байт", passwordMaxBytes)
+	ErrPasswordPersonal = errors.New("Пароль не должен содержать ваш email или имя")
+	ErrPasswordCommon   = errors.New("Этот пароль слишком распространен или встречался в утечках, выберите другой")
+)
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]struct{}
+)
+
+// CurrentPasswordPolicy возвращает политику из конфигурации
+func CurrentPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:        AppConfig.PasswordMinLength,
+		MinClasses:       AppConfig.PasswordMinClasses,
+		DisallowPersonal: AppConfig.PasswordDisallowPersonal,
+		CheckCommon:      AppConfig.PasswordCheckCommon,
+	}
+}
+
+// ValidatePassword проверяет новый пароль пользователя по текущей политике
+func ValidatePassword(password, email, name string) error {
+	return CurrentPasswordPolicy().Validate(password, email, name)
+}
+
+// Validate возвращает первую нарушенную проверку или nil
+func (p PasswordPolicy) Validate(password, email, name string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("Пароль должен содержать минимум %d символов", p.MinLength)
+	}
+
+	if len(password) > passwordMaxBytes {
+		return ErrPasswordTooLong
+	}
+
+	if p.MinClasses > 1 && passwordClasses(password) < p.MinClasses {
+		return fmt.Errorf("Пароль должен содержать символы минимум %d видов из: строчные буквы, заглавные буквы, цифры, спецсимволы", p.MinClasses)
+	}
+
+	if p.DisallowPersonal && containsPersonalData(password, email, name) {
+		return ErrPasswordPersonal
+	}
+
+	if p.CheckCommon && isCommonPassword(password) {
+		return ErrPasswordCommon
+	}
+
+	return nil
+}
+
+// Requirements - описание требований для подсказок в формах
+func (p PasswordPolicy) Requirements() string {
+	text := fmt.Sprintf("Пароль должен содержать минимум %d символов", p.MinLength)
+	if p.MinClasses > 1 {
+		text += fmt.Sprintf(" и символы минимум %d видов (строчные, заглавные, цифры, спецсимволы)", p.MinClasses)
+	}
+	return text
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// containsPersonalData ищет в пароле email, его имя до @ и имя пользователя
+// (слишком короткие части не проверяются, чтобы не отсекать случайные совпадения)
+func containsPersonalData(password, email, name string) bool {
+	lowered := strings.ToLower(password)
+
+	parts := []string{strings.ToLower(strings.TrimSpace(email))}
+	if local, _, ok := strings.Cut(parts[0], "@"); ok {
+		parts = append(parts, local)
+	}
+	parts = append(parts, strings.Fields(strings.ToLower(name))...)
+
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(lowered, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCommonPassword сверяет пароль со списком без учета регистра, в том числе
+// без цифр и знаков в конце ("Password123!" считается как "password")
+func isCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(loadCommonPasswords)
+
+	lowered := strings.ToLower(password)
+	if _, ok := commonPasswords[lowered]; ok {
+		return true
+	}
+
+	core := strings.TrimRightFunc(lowered, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if utf8.RuneCountInString(core) >= 4 {
+		if _, ok := commonPasswords[core]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func loadCommonPasswords() {
+	commonPasswords = make(map[string]struct{})
+	addPasswords(strings.NewReader(embeddedCommonPasswords))
+
+	path := AppConfig.PasswordBlocklistPath
+	if path == "" {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Ошибка открытия списка паролей %s: %v", path, err)
+		return
+	}
+	defer file.Close()
+
+	addPasswords(file)
+	log.Printf("Загружен список паролей %s, всего %d", path, len(commonPasswords))
+}
+
+func addPasswords(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commonPasswords[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Println("Ошибка чтения списка паролей:", err)
+	}
+}
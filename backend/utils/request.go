@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP возвращает IP клиента. Заголовки прокси учитываются
+// только при TRUST_PROXY_HEADERS=true, иначе их можно подделать.
+// Из заголовков берется только корректный адрес: ip_address - VARCHAR(45)
+func ClientIP(r *http.Request) string {
+	if AppConfig.TrustProxyHeaders {
+		// Первый адрес в цепочке - исходный клиент
+		for _, header := range []string{"X-Forwarded-For", "X-Real-IP"} {
+			for _, candidate := range strings.Split(r.Header.Get(header), ",") {
+				if ip := net.ParseIP(strings.TrimSpace(candidate)); ip != nil {
+					return ip.String()
+				}
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// DescribeDevice делает из User-Agent короткое описание "Браузер, ОС" для списка сессий
+func DescribeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Неизвестное устройство"
+	}
+
+	browser := "Неизвестный браузер"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"), strings.Contains(userAgent, "Opera"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "YaBrowser/"):
+		browser = "Яндекс Браузер"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(userAgent, "curl/"):
+		browser = "curl"
+	}
+
+	os := "неизвестная ОС"
+	switch {
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	return browser + ", " + os
+}
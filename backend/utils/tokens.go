@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// NewRandomToken генерирует криптографически стойкий случайный токен
+// из size байт и возвращает его в hex
+func NewRandomToken(size int) (string, error) {
+	buf := make([]byte, size)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// HashToken возвращает sha256 от токена в hex. В БД храним только хэш,
+// чтобы утечка таблицы не давала рабочих токенов
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,25 +3,34 @@ package utils
 import (
 	"html/template"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"online_store/models"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var AppConfig models.Config
 
+// ClearCookie удаляет cookie с access и refresh токенами
 func ClearCookie(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	for _, name := range []string{"auth_token", "refresh_token"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   AppConfig.CookieSecure,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   -1,
+		})
+	}
 }
 
 // InitConfig инициализирует конфигурацию
@@ -35,6 +44,12 @@ func InitConfig() {
 		log.Println(".env файл успешно загружен")
 	}
 
+	// Режим запуска: без APP_ENV=development небезопасные значения по умолчанию запрещены
+	AppConfig.AppEnv = os.Getenv("APP_ENV")
+	if AppConfig.AppEnv == "" {
+		AppConfig.AppEnv = "production"
+	}
+
 	// Путь к шаблонам
 	AppConfig.TemplatePath = os.Getenv("TEMPLATE_PATH")
 	if AppConfig.TemplatePath == "" {
@@ -47,10 +62,102 @@ func InitConfig() {
 		AppConfig.StaticPath = "static" // значение по умолчанию
 	}
 
+	// Доверять ли заголовкам X-Forwarded-For/X-Real-IP
+	AppConfig.TrustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+
+	// Cookie только по HTTPS (включать в production)
+	AppConfig.CookieSecure = os.Getenv("COOKIE_SECURE") == "true"
+
+	// Внешний адрес сайта: из него строятся ссылки в письмах
+	AppConfig.BaseURL = strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if AppConfig.BaseURL == "" {
+		AppConfig.BaseURL = "http://localhost:8080" // значение по умолчанию
+	}
+
+	// Внешний OpenID Connect провайдер
+	AppConfig.OIDCIssuer = os.Getenv("OIDC_ISSUER")
+	AppConfig.OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	AppConfig.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	AppConfig.OIDCDiscoveryURL = os.Getenv("OIDC_DISCOVERY_URL")
+	AppConfig.OIDCRedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	if AppConfig.OIDCRedirectURL == "" {
+		AppConfig.OIDCRedirectURL = AppConfig.BaseURL + "/auth/oidc/callback"
+	}
+	AppConfig.OIDCProviderName = os.Getenv("OIDC_PROVIDER_NAME")
+	if AppConfig.OIDCProviderName == "" {
+		AppConfig.OIDCProviderName = "OpenID"
+	}
+
+	// Политика паролей
+	AppConfig.PasswordMinLength = envInt("PASSWORD_MIN_LENGTH", 8)
+	AppConfig.PasswordMinClasses = envInt("PASSWORD_MIN_CLASSES", 2)
+	AppConfig.PasswordDisallowPersonal = os.Getenv("PASSWORD_DISALLOW_PERSONAL") != "false"
+	AppConfig.PasswordCheckCommon = os.Getenv("PASSWORD_CHECK_COMMON") != "false"
+	AppConfig.PasswordBlocklistPath = os.Getenv("PASSWORD_BLOCKLIST_PATH")
+
+	// Хеширование паролей: старые хеши перехешируются при входе
+	AppConfig.PasswordHashAlgorithm = os.Getenv("PASSWORD_HASH_ALGORITHM")
+	switch AppConfig.PasswordHashAlgorithm {
+	case "":
+		AppConfig.PasswordHashAlgorithm = HashAlgorithmBcrypt
+	case HashAlgorithmBcrypt, HashAlgorithmArgon2id:
+	default:
+		log.Fatalf("Неизвестный PASSWORD_HASH_ALGORITHM=%q (bcrypt или argon2id)", AppConfig.PasswordHashAlgorithm)
+	}
+	// Неверная стоимость bcrypt останавливает запуск, а не подменяется более слабой
+	AppConfig.BcryptCost = envIntInRange("BCRYPT_COST", 12, bcrypt.MinCost, bcrypt.MaxCost)
+	// argon2.IDKey паникует при t или p меньше 1, поэтому неверные параметры
+	// останавливают запуск, а не каждую регистрацию и каждый вход
+	AppConfig.Argon2Parallelism = uint8(envIntInRange("ARGON2_PARALLELISM", 2, 1, math.MaxUint8))
+	AppConfig.Argon2Iterations = uint32(envIntInRange("ARGON2_ITERATIONS", 3, 1, math.MaxInt32))
+	AppConfig.Argon2Memory = uint32(envIntInRange("ARGON2_MEMORY_KB", 64*1024,
+		8*int(AppConfig.Argon2Parallelism), math.MaxInt32))
+
+	// Ключи подписи JWT
+	InitJWTKeys()
+
+	// Ключ шифрования секретов в БД
+	InitDataEncryptionKey()
+
 	log.Printf("Конфигурация: templates=%s, static=%s",
 		AppConfig.TemplatePath, AppConfig.StaticPath)
 }
 
+// IsDevelopment сообщает, что приложение запущено в режиме разработки
+func IsDevelopment() bool {
+	return AppConfig.AppEnv == "development"
+}
+
+// envIntInRange читает целое число из переменной окружения; пустое значение -
+// defaultValue, нечисло или значение вне [min, max] - фатальная ошибка запуска
+func envIntInRange(name string, defaultValue, min, max int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		log.Fatalf("Некорректное значение %s=%q: нужно целое число от %d до %d", name, value, min, max)
+	}
+	return number
+}
+
+// envInt читает целое число из переменной окружения, при ошибке - значение по умолчанию
+func envInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Printf("Некорректное значение %s=%q, используется %d", name, value, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
 func PutFiles(mux *http.ServeMux) {
 	// Статические файлы
 	fileSystem := http.Dir(AppConfig.StaticPath)
@@ -61,6 +168,35 @@ func PutFiles(mux *http.ServeMux) {
 
 }
 
+// configFuncs - функции шаблонов, зависящие от конфигурации
+var configFuncs = template.FuncMap{
+	// passwordRequirements - подсказка о требованиях к паролю
+	"passwordRequirements": func() string {
+		return CurrentPasswordPolicy().Requirements()
+	},
+	// passwordMinLength - для атрибута minlength полей пароля
+	"passwordMinLength": func() int {
+		return AppConfig.PasswordMinLength
+	},
+	// highlight - текст с подсветкой найденных при поиске слов
+	"highlight": HighlightHTML,
+	// oidcProviderName - название внешнего провайдера входа, пусто если вход выключен
+	"oidcProviderName": func() string {
+		if AppConfig.OIDCIssuer == "" {
+			return ""
+		}
+		return AppConfig.OIDCProviderName
+	},
+}
+
+// HighlightHTML экранирует текст и заменяет маркеры поиска на <mark>
+func HighlightHTML(text string) template.HTML {
+	escaped := template.HTMLEscapeString(text)
+	escaped = strings.ReplaceAll(escaped, models.HighlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, models.HighlightEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
 // Вспомогательная функция для рендеринга шаблонов
 func RenderTemplate(w http.ResponseWriter, tmpl string, data interface{}) error {
 	// Путь к папке с шаблонами
@@ -71,7 +207,8 @@ func RenderTemplate(w http.ResponseWriter, tmpl string, data interface{}) error
 	log.Printf("Загрузка шаблона: %s", path)
 
 	// Парсим шаблон
-	t, err := template.ParseFiles(path)
+	// Функции csrfField/csrfToken регистрируются до парсинга, иначе шаблон не соберется
+	t, err := template.New(filepath.Base(path)).Funcs(csrfFuncs(w)).Funcs(impersonationFuncs(w)).Funcs(configFuncs).ParseFiles(path)
 	if err != nil {
 		log.Printf("Ошибка парсинга шаблона %s: %v", tmpl, err)
 		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
@@ -6,9 +6,77 @@ CREATE TABLE users (
     email VARCHAR(255) UNIQUE NOT NULL,
     password VARCHAR(255) NOT NULL,
     name VARCHAR(100) NOT NULL,
-    status VARCHAR(20) DEFAULT 'user'
+    -- Состояние аккаунта; роли и права - в user_roles/role_permissions
+    account_state VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (account_state IN ('active', 'banned', 'deleted')),
+    -- TOTP 2FA: секрет зашифрован AES-GCM ключом DATA_ENCRYPTION_KEY
+    totp_secret TEXT,
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    totp_last_step BIGINT NOT NULL DEFAULT 0
 );
 
+-- User identities (привязка аккаунта к внешнему OpenID Connect провайдеру)
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, subject)
+);
+
+-- Roles (роли сотрудников; у обычного покупателя ролей нет)
+CREATE TABLE roles (
+    role_id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description VARCHAR(255)
+);
+
+-- Permissions (права, которые проверяют обработчики)
+CREATE TABLE permissions (
+    permission_id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description VARCHAR(255)
+);
+
+CREATE TABLE role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(permission_id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(role_id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO permissions (name, description) VALUES
+  ('admin.access', 'Вход в админ-панель'),
+  ('products.write', 'Добавление, изменение, удаление и импорт товаров'),
+  ('reports.read', 'Отчеты и экспорт данных'),
+  ('users.ban', 'Бан и разбан пользователей'),
+  ('security.manage', 'Просмотр и снятие блокировок входа'),
+  ('roles.manage', 'Назначение ролей пользователям'),
+  ('audit.read', 'Просмотр и выгрузка журнала действий'),
+  ('users.impersonate', 'Вход под покупателем для поддержки');
+
+INSERT INTO roles (name, description) VALUES
+  ('admin', 'Администратор'),
+  ('warehouse', 'Кладовщик: товары и остатки');
+
+-- Администратор получает все права
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.permission_id
+FROM roles CROSS JOIN permissions
+WHERE roles.name = 'admin';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.permission_id
+FROM roles JOIN permissions ON permissions.name IN ('admin.access', 'products.write', 'reports.read')
+WHERE roles.name = 'warehouse';
+
 -- Products table (товары)
 CREATE TABLE products (
     product_id SERIAL PRIMARY KEY,
@@ -16,7 +84,12 @@ CREATE TABLE products (
     description TEXT,
     price NUMERIC(10,2) NOT NULL CHECK (price >= 0),
     stock_qty INTEGER NOT NULL DEFAULT 0 CHECK (stock_qty >= 0),
-    image_url VARCHAR(255)
+    image_url VARCHAR(255),
+    -- Полнотекстовый поиск: название, производитель, категория и описание;
+    -- пересчитывается триггерами refresh_product_search_vector
+    search_vector TSVECTOR NOT NULL DEFAULT ''::tsvector,
+    -- Адрес страницы товара /product/{slug}, заполняется триггером products_slug_update
+    slug TEXT UNIQUE NOT NULL
 );
 
 -- Categories table (категории)
@@ -47,6 +120,186 @@ CREATE TABLE products_producers (
     PRIMARY KEY (product_id, producer_id)
 );
 
+-- Дополнительные изображения для галереи на странице товара;
+-- главное изображение - products.image_url
+CREATE TABLE product_images (
+    image_id SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    image_url VARCHAR(255) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0
+);
+
+-- Варианты товара (вкус, фасовка) со своим артикулом, ценой, остатком и изображением.
+-- У товара с вариантами products.price - минимальная цена, products.stock_qty - сумма
+-- остатков вариантов (триггер product_variants_totals_update), поэтому каталог
+-- и фильтры работают с товаром, а в корзину и заказ попадает конкретный вариант
+CREATE TABLE product_variants (
+    variant_id SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES products(product_id) ON DELETE CASCADE,
+    sku VARCHAR(64) UNIQUE NOT NULL,
+    flavor VARCHAR(100),
+    size VARCHAR(50),
+    price NUMERIC(10,2) NOT NULL CHECK (price >= 0),
+    stock_qty INTEGER NOT NULL DEFAULT 0 CHECK (stock_qty >= 0),
+    image_url VARCHAR(255), -- NULL - изображение товара
+    position INTEGER NOT NULL DEFAULT 0,
+    CHECK (flavor IS NOT NULL OR size IS NOT NULL)
+);
+
+CREATE FUNCTION refresh_product_variant_totals(p_product_id INTEGER) RETURNS void AS $$
+    UPDATE products SET price = totals.min_price, stock_qty = totals.total_stock
+    FROM (
+        SELECT min(price) AS min_price, sum(stock_qty) AS total_stock
+        FROM product_variants
+        WHERE product_id = p_product_id
+    ) AS totals
+    WHERE products.product_id = p_product_id AND totals.min_price IS NOT NULL;
+$$ LANGUAGE sql;
+
+CREATE FUNCTION product_variants_totals_trigger() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM refresh_product_variant_totals(OLD.product_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM refresh_product_variant_totals(NEW.product_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER product_variants_totals_update
+AFTER INSERT OR UPDATE OR DELETE ON product_variants
+FOR EACH ROW EXECUTE FUNCTION product_variants_totals_trigger();
+
+-- Slug товара: транслитерированное название и ID, например
+-- "optimum-nutrition-gold-standard-100-whey-600g-1". ID делает slug уникальным
+-- и позволяет найти товар по старому адресу после переименования
+CREATE FUNCTION product_slug(p_name TEXT, p_product_id INTEGER) RETURNS TEXT AS $$
+    SELECT concat_ws('-', nullif(trim(BOTH '-' FROM regexp_replace(
+        translate(
+            replace(replace(replace(replace(replace(replace(lower(p_name),
+                'ж', 'zh'), 'ч', 'ch'), 'ш', 'sh'), 'щ', 'sch'), 'ю', 'yu'), 'я', 'ya'),
+            'абвгдеёзийклмнопрстуфхцыэъь', 'abvgdeezijklmnoprstufhcye'),
+        '[^a-z0-9]+', '-', 'g')), ''), p_product_id::text);
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE FUNCTION products_slug_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.slug := product_slug(NEW.name, NEW.product_id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER products_slug_update
+BEFORE INSERT OR UPDATE OF name ON products
+FOR EACH ROW EXECUTE FUNCTION products_slug_trigger();
+
+-- Поисковый вектор товара в русской и английской конфигурациях.
+-- Веса: название - A, производитель и категория - B, описание - C.
+-- Производитель и категория лежат в других таблицах, поэтому вектор
+-- хранится в products и обновляется триггерами на всех четырех таблицах
+CREATE FUNCTION refresh_product_search_vector(p_product_id INTEGER) RETURNS void AS $$
+    UPDATE products SET search_vector =
+        setweight(to_tsvector('russian', products.name), 'A') ||
+        setweight(to_tsvector('english', products.name), 'A') ||
+        setweight(to_tsvector('russian', coalesce(links.producers, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(links.producers, '')), 'B') ||
+        setweight(to_tsvector('russian', coalesce(links.categories, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(links.categories, '')), 'B') ||
+        setweight(to_tsvector('russian', coalesce(products.description, '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(products.description, '')), 'C')
+    FROM (
+        SELECT
+            (SELECT string_agg(producers.name, ' ')
+             FROM products_producers JOIN producers ON producers.producer_id = products_producers.producer_id
+             WHERE products_producers.product_id = p_product_id) AS producers,
+            (SELECT string_agg(categories.name, ' ')
+             FROM products_categories JOIN categories ON categories.category_id = products_categories.category_id
+             WHERE products_categories.product_id = p_product_id) AS categories
+    ) AS links
+    WHERE products.product_id = p_product_id;
+$$ LANGUAGE sql;
+
+CREATE FUNCTION products_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_product_search_vector(NEW.product_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Привязка к категории или производителю изменилась
+CREATE FUNCTION product_links_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM refresh_product_search_vector(OLD.product_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM refresh_product_search_vector(NEW.product_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Категорию или производителя переименовали
+CREATE FUNCTION category_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_product_search_vector(product_id)
+    FROM products_categories WHERE category_id = NEW.category_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION producer_search_vector_trigger() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_product_search_vector(product_id)
+    FROM products_producers WHERE producer_id = NEW.producer_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- UPDATE OF name, description: обновление самого search_vector триггер не вызывает
+CREATE TRIGGER products_search_vector_update
+AFTER INSERT OR UPDATE OF name, description ON products
+FOR EACH ROW EXECUTE FUNCTION products_search_vector_trigger();
+
+CREATE TRIGGER products_categories_search_vector_update
+AFTER INSERT OR UPDATE OR DELETE ON products_categories
+FOR EACH ROW EXECUTE FUNCTION product_links_search_vector_trigger();
+
+CREATE TRIGGER products_producers_search_vector_update
+AFTER INSERT OR UPDATE OR DELETE ON products_producers
+FOR EACH ROW EXECUTE FUNCTION product_links_search_vector_trigger();
+
+CREATE TRIGGER categories_search_vector_update
+AFTER UPDATE OF name ON categories
+FOR EACH ROW EXECUTE FUNCTION category_search_vector_trigger();
+
+CREATE TRIGGER producers_search_vector_update
+AFTER UPDATE OF name ON producers
+FOR EACH ROW EXECUTE FUNCTION producer_search_vector_trigger();
+
+-- Триграммы: индекс для поиска подстроки в названии (ILIKE '%...%'),
+-- поиск с опечатками и подсказки (сходство триграмм)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Словарь для "Возможно, вы искали": слова из названий товаров,
+-- производителей и категорий (конфигурация simple - без стемминга).
+-- Материализован и проиндексирован триграммами (idx_search_words_trgm),
+-- чтобы подбор слова не разбирал все названия на каждый запрос.
+-- Пересчитывается фоновой задачей (REFRESH ... CONCURRENTLY, нужен
+-- уникальный индекс idx_search_words_word), а не в транзакции записи
+CREATE MATERIALIZED VIEW search_words AS
+SELECT DISTINCT word
+FROM (
+    SELECT unnest(tsvector_to_array(to_tsvector('simple', name))) AS word FROM products
+    UNION ALL
+    SELECT unnest(tsvector_to_array(to_tsvector('simple', name))) FROM producers
+    UNION ALL
+    SELECT unnest(tsvector_to_array(to_tsvector('simple', name))) FROM categories
+) AS words
+WHERE length(word) >= 3;
+
 -- Shopping carts (корзины)
 CREATE TABLE carts (
     cart_id SERIAL PRIMARY KEY,
@@ -58,8 +311,10 @@ CREATE TABLE cart_items (
     cart_item_id SERIAL PRIMARY KEY,
     cart_id INTEGER REFERENCES carts(cart_id) ON DELETE CASCADE,
     product_id INTEGER REFERENCES products(product_id) ON DELETE CASCADE,
+    -- Вариант обязателен, если у товара есть варианты, иначе NULL
+    variant_id INTEGER REFERENCES product_variants(variant_id) ON DELETE CASCADE,
     quantity INTEGER NOT NULL CHECK (quantity > 0),
-    UNIQUE (cart_id, product_id)
+    UNIQUE NULLS NOT DISTINCT (cart_id, product_id, variant_id)
 );
 
 -- Orders (заказы)
@@ -72,11 +327,12 @@ CREATE TABLE orders (
 
 -- Order items (элементы заказа)
 CREATE TABLE order_items (
+    order_item_id SERIAL PRIMARY KEY,
     order_id INTEGER REFERENCES orders(order_id) ON DELETE CASCADE,
     product_id INTEGER REFERENCES products(product_id) ON DELETE CASCADE,
+    variant_id INTEGER REFERENCES product_variants(variant_id) ON DELETE SET NULL,
     quantity INTEGER NOT NULL CHECK (quantity > 0),
-    unit_price NUMERIC(10,2) NOT NULL CHECK (unit_price >= 0),
-    PRIMARY KEY (order_id, product_id)
+    unit_price NUMERIC(10,2) NOT NULL CHECK (unit_price >= 0)
 );
 
 -- Pending registrations (ожидающие регистрации)
@@ -85,15 +341,147 @@ CREATE TABLE pending_registrations (
     email VARCHAR(255) UNIQUE NOT NULL,
     username VARCHAR(100) NOT NULL,
     password VARCHAR(255) NOT NULL,
-    verification_code INTEGER NOT NULL
+    verification_code INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    -- Повторные отправки кода, пока не истек предыдущий (не сбрасываются при отправке)
+    resends INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
 );
 
 -- Forgot password data (восстановление пароля)
 CREATE TABLE forgot_password_date (
     email VARCHAR(255) UNIQUE NOT NULL,
-    verification_code INTEGER NOT NULL
+    verification_code INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    -- Повторные отправки кода, пока не истек предыдущий (не сбрасываются при отправке)
+    resends INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Email change requests (смена email до подтверждения кодом с нового адреса)
+CREATE TABLE email_change_requests (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    verification_code INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- Password reset tokens (одноразовые токены сброса пароля, хранится только sha256)
+CREATE TABLE password_reset_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+-- Magic link tokens (одноразовые ссылки для входа без пароля, хранится только sha256)
+CREATE TABLE magic_link_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+-- Login throttle (неудачные входы по email и по IP, временные блокировки)
+CREATE TABLE login_throttle (
+    scope VARCHAR(10) NOT NULL CHECK (scope IN ('email', 'ip')),
+    subject VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ,
+    PRIMARY KEY (scope, subject)
+);
+
+-- Recovery codes (одноразовые коды восстановления 2FA, хранится только sha256)
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+-- Sessions (серверные сессии, отзываются при выходе/смене пароля/бане)
+CREATE TABLE sessions (
+    session_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(45),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    -- Вход сотрудника под покупателем: кто вошел и из какой своей сессии,
+    -- чтобы вернуть его туда после выхода из режима
+    impersonator_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    impersonator_session_id VARCHAR(64)
+);
+
+-- Refresh tokens (ротируемые токены; семейство = сессия, хранится только sha256)
+CREATE TABLE refresh_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    session_id VARCHAR(64) NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    -- Единственный разрешенный повтор после погашения (окно grace) уже был
+    grace_used_at TIMESTAMPTZ
+);
+
+-- Audit log (журнал действий сотрудников; before/after - только измененные поля)
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    actor_email VARCHAR(255) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(100),
+    before JSONB,
+    after JSONB,
+    ip_address VARCHAR(45),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Personal API tokens (персональные токены для скриптов; хранится только sha256)
+CREATE TABLE api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
 );
 
 CREATE INDEX idx_producers_name ON producers(name);
 CREATE INDEX idx_products_name ON products(name);
 CREATE INDEX idx_products_price ON products(price);
+CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector);
+CREATE INDEX idx_products_name_trgm ON products USING GIN (name gin_trgm_ops);
+CREATE UNIQUE INDEX idx_search_words_word ON search_words(word);
+CREATE INDEX idx_search_words_trgm ON search_words USING GIN (word gin_trgm_ops);
+CREATE INDEX idx_product_images_product_id ON product_images(product_id);
+CREATE INDEX idx_product_variants_product_id ON product_variants(product_id);
+CREATE INDEX idx_order_items_order_id ON order_items(order_id);
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+CREATE INDEX idx_user_roles_role_id ON user_roles(role_id);
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX idx_audit_log_entity ON audit_log(entity_type, entity_id);
+CREATE INDEX idx_audit_log_actor_id ON audit_log(actor_id);
+CREATE INDEX idx_magic_link_tokens_user_id ON magic_link_tokens(user_id);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
@@ -11,6 +11,7 @@ function applyFilters() {
     if (priceMin) params.append('price_min', priceMin);
     if (priceMax) params.append('price_max', priceMax);
     if (sortBy) params.append('sortBy', sortBy);
+    if (document.querySelector('input[name="in_stock"]:checked')) params.append('in_stock', '1');
     
     document.querySelectorAll('input[name="category"]:checked').forEach(cb => {
         params.append('category', cb.value);
@@ -52,6 +53,101 @@ function validateQuantity(productId, stockQuantity) {
     input.value = value;
 }
 
+// Подсказки строки поиска (/search/suggest)
+const suggestionGroups = [
+    { key: 'products', title: 'Товары' },
+    { key: 'producers', title: 'Бренды' },
+    { key: 'categories', title: 'Категории' }
+];
+
+function renderSuggestions(box, data) {
+    box.innerHTML = '';
+
+    suggestionGroups.forEach(group => {
+        const items = data[group.key] || [];
+        if (items.length === 0) return;
+
+        const title = document.createElement('div');
+        title.className = 'suggestion-group-title';
+        title.textContent = group.title;
+        box.appendChild(title);
+
+        items.forEach(item => {
+            const link = document.createElement('a');
+            link.className = 'suggestion-item';
+            link.href = item.url;
+            link.textContent = item.name;
+            box.appendChild(link);
+        });
+    });
+
+    box.hidden = box.childElementCount === 0;
+}
+
+function initSearchSuggestions() {
+    const searchInput = document.getElementById('search');
+    const box = document.getElementById('search-suggestions');
+    if (!searchInput || !box) return;
+
+    let suggestTimeout;
+    let lastQuery = '';
+
+    searchInput.addEventListener('input', function() {
+        clearTimeout(suggestTimeout);
+        const query = this.value.trim();
+
+        if (query.length < 2) {
+            box.hidden = true;
+            return;
+        }
+
+        suggestTimeout = setTimeout(() => {
+            lastQuery = query;
+            fetch('/search/suggest?q=' + encodeURIComponent(query))
+                .then(response => response.json())
+                .then(body => {
+                    // Ответ на устаревший запрос не показываем
+                    if (query === lastQuery && body.data) {
+                        renderSuggestions(box, body.data);
+                    }
+                })
+                .catch(() => { box.hidden = true; });
+        }, 250);
+    });
+
+    // Стрелки выбирают подсказку, Enter открывает ее или применяет фильтры
+    searchInput.addEventListener('keydown', function(e) {
+        const items = Array.from(box.querySelectorAll('.suggestion-item'));
+        const current = items.findIndex(item => item.classList.contains('active'));
+
+        if ((e.key === 'ArrowDown' || e.key === 'ArrowUp') && !box.hidden && items.length > 0) {
+            e.preventDefault();
+            const next = e.key === 'ArrowDown'
+                ? (current + 1) % items.length
+                : (current - 1 + items.length) % items.length;
+            items.forEach(item => item.classList.remove('active'));
+            items[next].classList.add('active');
+            items[next].scrollIntoView({ block: 'nearest' });
+        } else if (e.key === 'Enter') {
+            e.preventDefault();
+            if (!box.hidden && current >= 0) {
+                window.location.href = items[current].href;
+            } else {
+                applyFilters();
+            }
+        } else if (e.key === 'Escape') {
+            box.hidden = true;
+        }
+    });
+
+    // Клик вне строки поиска закрывает подсказки
+    document.addEventListener('click', function(e) {
+        if (!box.contains(e.target) && e.target !== searchInput) {
+            box.hidden = true;
+        }
+    });
+}
+
 // Инициализация при загрузке страницы
 function initCatalog() {
     // Обработчики событий для кнопок +/-
@@ -72,15 +168,8 @@ function initCatalog() {
         });
     });
 
-    // Автопоиск с задержкой
-    const searchInput = document.getElementById('search');
-    if (searchInput) {
-        let searchTimeout;
-        searchInput.addEventListener('input', function(e) {
-            clearTimeout(searchTimeout);
-            searchTimeout = setTimeout(applyFilters, 500);
-        });
-    }
+    // Подсказки при вводе, поиск - по Enter
+    initSearchSuggestions();
 
     // Обработчик сортировки
     const sortSelect = document.getElementById('sortBy');
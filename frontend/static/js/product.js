@@ -0,0 +1,105 @@
+// Страница товара: галерея и выбор варианта (вкус, фасовка)
+
+// Галерея: миниатюра заменяет главное изображение
+
+function initGallery() {
+    const mainImage = document.getElementById('gallery-main-image');
+    if (!mainImage) return;
+
+    document.querySelectorAll('.gallery-thumb').forEach(thumb => {
+        thumb.addEventListener('click', function() {
+            mainImage.src = this.getAttribute('data-image');
+            mainImage.style.display = '';
+            mainImage.nextElementSibling.style.display = 'none';
+
+            document.querySelectorAll('.gallery-thumb').forEach(t => t.classList.remove('active'));
+            this.classList.add('active');
+        });
+    });
+}
+
+// Варианты: общий список variant_id уходит в форму. Если у товара есть и
+// вкус, и фасовка, он скрывается и выбирается по двум отдельным спискам
+function initVariants() {
+    const variantSelect = document.getElementById('variant-select');
+    if (!variantSelect) return;
+
+    const flavorSelect = document.getElementById('variant-flavor');
+    const sizeSelect = document.getElementById('variant-size');
+    const options = Array.from(variantSelect.options);
+
+    if (flavorSelect && sizeSelect) {
+        [flavorSelect, sizeSelect].forEach(select => {
+            select.disabled = false;
+            select.closest('.variant-field').hidden = false;
+        });
+        document.getElementById('variant-field-combined').hidden = true;
+
+        const syncFromDimensions = changed => {
+            let match = options.find(option =>
+                option.dataset.flavor === flavorSelect.value && option.dataset.size === sizeSelect.value);
+
+            // Такого сочетания нет - берем первый вариант с только что выбранным значением
+            if (!match) {
+                const dimension = changed.getAttribute('data-dimension');
+                match = options.find(option => option.dataset[dimension] === changed.value);
+                flavorSelect.value = match.dataset.flavor;
+                sizeSelect.value = match.dataset.size;
+            }
+
+            variantSelect.value = match.value;
+            showVariant(match);
+        };
+
+        flavorSelect.addEventListener('change', () => syncFromDimensions(flavorSelect));
+        sizeSelect.addEventListener('change', () => syncFromDimensions(sizeSelect));
+    }
+
+    variantSelect.addEventListener('change', () => showVariant(variantSelect.selectedOptions[0]));
+}
+
+// showVariant выводит цену, остаток, артикул и изображение варианта
+function showVariant(option) {
+    const stock = parseInt(option.dataset.stock) || 0;
+
+    document.getElementById('product-price').textContent = option.dataset.price + ' BYN';
+
+    const stockBlock = document.getElementById('product-stock');
+    stockBlock.innerHTML = '';
+    const stockLabel = document.createElement('span');
+    stockLabel.className = stock > 0 ? 'in-stock' : 'out-of-stock';
+    stockLabel.textContent = stock > 0 ? 'В наличии: ' + stock + ' шт.' : 'Нет в наличии';
+    stockBlock.appendChild(stockLabel);
+
+    const sku = document.getElementById('product-sku');
+    if (sku) sku.textContent = option.dataset.sku;
+
+    const form = option.closest('form');
+    const qtyInput = form.querySelector('.qty-input');
+    qtyInput.max = stock;
+    qtyInput.value = 1;
+    form.querySelectorAll('.qty-btn, .qty-input').forEach(el => el.disabled = stock <= 0);
+
+    const submit = document.getElementById('product-add-to-cart');
+    submit.disabled = stock <= 0;
+    submit.textContent = stock > 0 ? 'В корзину' : 'Нет в наличии';
+
+    const mainImage = document.getElementById('gallery-main-image');
+    const base = document.querySelector('.gallery-main').getAttribute('data-image-base');
+    if (mainImage && option.dataset.image) {
+        mainImage.src = base + option.dataset.image;
+        mainImage.style.display = '';
+        mainImage.nextElementSibling.style.display = 'none';
+        document.querySelectorAll('.gallery-thumb').forEach(t => t.classList.remove('active'));
+    }
+
+    // Адрес страницы указывает на выбранный вариант
+    const url = new URL(window.location.href);
+    url.searchParams.set('variant', option.value);
+    history.replaceState(null, '', url);
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    initGallery();
+    initVariants();
+});